@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	commandMode         = "-mode"
+	commandRoomPassword = "-roompass"
+	commandInvite       = "-invite"
+	roomModeHidden      = "hidden"
+	roomModeInvite      = "invite"
+	headerRoomPassword  = "Room-Password"
+	parameterPassword   = "password"
+	messageRoomPassword = "The room %s requires a password. What is the password?"
+)
+
+var (
+	errNotInvited   = errors.New("user has not been invited to the room")
+	errRoomPassword = errors.New("incorrect room password")
+)
+
+// IsHidden checks if the room is left out of the list of rooms.
+func (room *ChatRoom) IsHidden() bool {
+	room.moderationLock.RLock()
+	defer room.moderationLock.RUnlock()
+	return room.hidden
+}
+
+// IsInviteOnly checks if only invited users can enter the room.
+func (room *ChatRoom) IsInviteOnly() bool {
+	room.moderationLock.RLock()
+	defer room.moderationLock.RUnlock()
+	return room.inviteOnly
+}
+
+// HasPassword checks if a password is required to enter the room.
+func (room *ChatRoom) HasPassword() bool {
+	room.moderationLock.RLock()
+	defer room.moderationLock.RUnlock()
+	return len(room.passwordHash) != 0
+}
+
+// IsRestricted checks if the room is invite only or requires a password, so not everyone can enter or read the room.
+func (room *ChatRoom) IsRestricted() bool {
+	return room.IsInviteOnly() || room.HasPassword()
+}
+
+// SetModes sets whether the room is hidden and whether the room is invite only. When the room becomes invite only, the
+// users already in the room are invited.
+func (room *ChatRoom) SetModes(hidden bool, inviteOnly bool) {
+	users := room.GetUsers()
+	room.moderationLock.Lock()
+	room.hidden = hidden
+	if inviteOnly && !room.inviteOnly {
+		for _, userName := range users {
+			room.invited[userName] = true
+		}
+	}
+	room.inviteOnly = inviteOnly
+	room.moderationLock.Unlock()
+	server.saveStateLater()
+}
+
+// SetPassword requires the password to enter the room. The password is stored as a salted hash. A blank password removes
+// the password.
+func (room *ChatRoom) SetPassword(password string) error {
+	passwordHash, err := hashRoomPassword(room.Name, password)
+	if err != nil {
+		return err
+	}
+	room.moderationLock.Lock()
+	room.passwordHash = passwordHash
+	room.moderationLock.Unlock()
+	server.saveStateLater()
+	return nil
+}
+
+// hashRoomPassword hashes the password of the room with a salt. A blank password has no hash.
+func hashRoomPassword(roomName string, password string) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, nil
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to hash the password of room %s", roomName)
+	}
+	return passwordHash, nil
+}
+
+// Invite allows the user to enter the room when the room is invite only.
+func (room *ChatRoom) Invite(userName string) {
+	room.moderationLock.Lock()
+	room.invited[userName] = true
+	room.moderationLock.Unlock()
+	server.saveStateLater()
+}
+
+// IsInvited checks if the user has been invited to the room.
+func (room *ChatRoom) IsInvited(userName string) bool {
+	room.moderationLock.RLock()
+	defer room.moderationLock.RUnlock()
+	return room.invited[userName]
+}
+
+// GetInvited retrieves the names of the users invited to the room, sorted by name.
+func (room *ChatRoom) GetInvited() []string {
+	room.moderationLock.RLock()
+	invited := make([]string, 0, len(room.invited))
+	for userName := range room.invited {
+		invited = append(invited, userName)
+	}
+	room.moderationLock.RUnlock()
+	sort.Strings(invited)
+	return invited
+}
+
+// CheckAccess checks the user can enter the room with the password. Moderators can always enter. Otherwise, the user must
+// be invited if the room is invite only and the password must match if the room has a password.
+func (room *ChatRoom) CheckAccess(userName string, password string) error {
+	if room.IsModerator(userName) {
+		return nil
+	}
+	room.moderationLock.RLock()
+	inviteOnly := room.inviteOnly
+	invited := room.invited[userName]
+	passwordHash := room.passwordHash
+	room.moderationLock.RUnlock()
+	if inviteOnly && !invited {
+		return errors.Wrapf(errNotInvited, "%s cannot enter room %s", userName, room.Name)
+	}
+	if len(passwordHash) != 0 && bcrypt.CompareHashAndPassword(passwordHash, []byte(password)) != nil {
+		return errors.Wrapf(errRoomPassword, "%s cannot enter room %s", userName, room.Name)
+	}
+	return nil
+}
+
+// configureRoom handles the commands that change who can see and enter the room. Only the owner of the room can use them.
+func (user *ChatUser) configureRoom(command string, msg string, room *ChatRoom) {
+	if !room.IsOwner(user.Name) {
+		user.ReceiveMessage(fmt.Sprintf(messageNotOwner, command))
+		return
+	}
+	parts := strings.SplitN(msg, " ", 2)
+	if command == commandRoomPassword {
+		var password string
+		if len(parts) > 1 {
+			password = parts[1]
+		}
+		if err := room.SetPassword(password); err != nil {
+			logger.Printf("ERROR: failed to set the password of room %s: %+v\n", room.Name, err)
+			user.ReceiveMessage("Failed to set the password of the room.")
+		} else if len(password) == 0 {
+			room.Broadcast("The room no longer requires a password")
+		} else {
+			room.Broadcast("The room now requires a password")
+		}
+		return
+	}
+	var hidden, inviteOnly bool
+	for _, mode := range strings.Fields(msg)[1:] {
+		switch mode {
+		case roomModeHidden:
+			hidden = true
+		case roomModeInvite:
+			inviteOnly = true
+		default:
+			user.ReceiveMessage(fmt.Sprintf("Unknown mode %s. Modes are %s and %s.", mode, roomModeHidden, roomModeInvite))
+			return
+		}
+	}
+	room.SetModes(hidden, inviteOnly)
+	var modes []string
+	if hidden {
+		modes = append(modes, roomModeHidden)
+	}
+	if inviteOnly {
+		modes = append(modes, "invite only")
+	}
+	if len(modes) == 0 {
+		modes = append(modes, "public")
+	}
+	room.Broadcast(fmt.Sprintf("The room is now %s", strings.Join(modes, " and ")))
+}
+
+// invite lets the user in the message enter the room. Only users in the room can invite others.
+func (user *ChatUser) invite(msg string, room *ChatRoom) {
+	parts := strings.Fields(msg)
+	if len(parts) < 2 {
+		user.ReceiveMessage("A user Name is required.")
+		return
+	}
+	if !room.HasUser(user.Name) && !room.IsModerator(user.Name) {
+		user.ReceiveMessage(fmt.Sprintf("Only users in the room can use %s.", commandInvite))
+		return
+	}
+	if room.IsBanned(parts[1], "") {
+		user.ReceiveMessage(fmt.Sprintf("%s is banned from the room.", parts[1]))
+		return
+	}
+	room.Invite(parts[1])
+	user.ReceiveMessage(fmt.Sprintf("%s has been invited to the room.", parts[1]))
+	if invitedUser := server.GetUser(parts[1]); invitedUser != nil {
+		invitedUser.ReceiveMessage(fmt.Sprintf("%s invited you to the room %s. Use %s %s to enter.", user.Name, room.Name, commandChangeRoom, room.Name))
+	}
+}
+
+// roomErrorMessage describes why the user could not enter the room.
+func roomErrorMessage(roomName string, err error) string {
+	switch errors.Cause(err) {
+	case errBanned:
+		return fmt.Sprintf("You are banned from the room %s.", roomName)
+	case errNotInvited:
+		return fmt.Sprintf("The room %s is invite only. Ask a user in the room to invite you with %s.", roomName, commandInvite)
+	case errRoomPassword:
+		return fmt.Sprintf("Incorrect password for the room %s.", roomName)
+	default:
+		return fmt.Sprintf("Could not enter the room %s.", roomName)
+	}
+}
+
+// authorizeRoom checks the request can read the room. Restricted rooms require the requester to be able to enter the room,
+// identified by the bearer token or the basic authentication credentials of a registered account, with the password in
+// the 'Room-Password' header. The 'Sender-Name' header is not trusted, as anyone could claim the name of an invited user.
+// If the request is not authorized, an error response is written and false is returned.
+func authorizeRoom(writer http.ResponseWriter, request *http.Request, room *ChatRoom) bool {
+	if !room.IsRestricted() {
+		return true
+	}
+	userName, ok := authenticateRequester(writer, request, scopeRead)
+	if !ok {
+		return false
+	} else if len(userName) == 0 {
+		writeCredentialsRequired(writer, `{"statusCode":"401", "reason":"A token or the credentials of a registered name are required for the room"}`)
+		return false
+	}
+	if err := room.CheckAccess(userName, request.Header.Get(headerRoomPassword)); err != nil {
+		writeRoomForbidden(writer, userName, room.Name, err)
+		return false
+	}
+	return true
+}
+
+// authenticateRequester identifies the user making the request by the bearer token granting the scope or the basic
+// authentication credentials of a registered account. If the request has neither, a blank name is returned. If the token
+// or credentials are not valid, an error response is written and false is returned.
+func authenticateRequester(writer http.ResponseWriter, request *http.Request, scope string) (string, bool) {
+	token, ok := authenticateToken(writer, request, scope)
+	if !ok {
+		return "", false
+	} else if token != nil {
+		return token.Name, true
+	}
+	userName, password, ok := request.BasicAuth()
+	if !ok {
+		return "", true
+	}
+	if err := server.Authenticate(userName, password); err != nil {
+		writer.Header().Set(headerAuthenticate, authenticateChallenge)
+		writer.WriteHeader(http.StatusUnauthorized)
+		logger.Printf("ERROR: HTTP request has invalid credentials for %s: %+v\n", userName, err)
+		writeHttpMessage(writer, `{"statusCode":"401", "reason":"Valid credentials are required for the name"}`)
+		return "", false
+	}
+	return userName, true
+}
+
+// writeRoomForbidden writes the response for a user that cannot enter the room.
+func writeRoomForbidden(writer http.ResponseWriter, userName string, roomName string, err error) {
+	writer.Header().Set(headerContentType, headerContentTypeJSON)
+	writer.WriteHeader(http.StatusForbidden)
+	logger.Printf("ERROR: HTTP user %s cannot enter room %s: %+v\n", userName, roomName, err)
+	switch errors.Cause(err) {
+	case errBanned:
+		writeHttpMessage(writer, `{"statusCode":"403", "reason":"User is banned from the room"}`)
+	case errNotInvited:
+		writeHttpMessage(writer, `{"statusCode":"403", "reason":"User has not been invited to the room"}`)
+	default:
+		writeHttpMessage(writer, `{"statusCode":"403", "reason":"The room password is missing or incorrect"}`)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChatServer_ListRooms_hidden(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	server.CreateRoomIfMissing("publicRoom")
+	server.CreateRoomIfMissing("hiddenRoom").SetModes(true, false)
+	rooms := server.ListRooms()
+	if len(rooms) != 1 || rooms[0] != "publicRoom" {
+		t.Fatalf("expected only the public room to be listed. Actual rooms %v", rooms)
+	}
+}
+
+func TestChatServer_OpenRoom_inviteOnlyAndPassword(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	if err := server.RegisterAccount("owner", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	room, err := server.OpenRoom("leads", "owner", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.SetModes(false, true)
+	if _, err = server.OpenRoom("leads", "guest", "", ""); errors.Cause(err) != errNotInvited {
+		t.Fatalf("expected a user that was not invited to be rejected. Actual error %+v", err)
+	}
+	room.Invite("guest")
+	if _, err = server.OpenRoom("leads", "guest", "", ""); err != nil {
+		t.Fatalf("expected the invited user to enter the room: %+v", err)
+	}
+	if err = room.SetPassword("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.OpenRoom("leads", "guest", "", "wrong"); errors.Cause(err) != errRoomPassword {
+		t.Fatalf("expected an incorrect password to be rejected. Actual error %+v", err)
+	}
+	if _, err = server.OpenRoom("leads", "guest", "", "secret"); err != nil {
+		t.Fatalf("expected the password to be accepted: %+v", err)
+	}
+	if _, err = server.OpenRoom("leads", "owner", "", ""); err != nil {
+		t.Fatalf("expected the owner to always enter the room: %+v", err)
+	}
+}
+
+func TestChatUser_handleInput_passwordRoom(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	if err := server.RegisterAccount("accessOwner", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	room, err := server.OpenRoom("accessRoom", "accessOwner", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ownerOutput, guestOutput bytes.Buffer
+	owner := ChatUser{Name: "accessOwner", writer: &ownerOutput}
+	guest := ChatUser{Name: "accessGuest", writer: &guestOutput}
+	server.AddUser(&owner)
+	server.AddUser(&guest)
+	room.AddUser(owner.Name)
+	mainRoom := server.GetRoom(defaultRoom)
+	mainRoom.AddUser(guest.Name)
+	owner.handleInput("-roompass secret", room)
+	//
+	// The guest is asked for the password, and the next line is the password
+	//
+	selectedRoom, _ := guest.handleInput("-r accessRoom", mainRoom)
+	if selectedRoom != mainRoom || !strings.Contains(guestOutput.String(), "The room accessRoom requires a password.") {
+		t.Fatalf("expected the guest to be asked for the password. Actual output %s", guestOutput.String())
+	}
+	selectedRoom, _ = guest.handleInput("wrong", selectedRoom)
+	if selectedRoom != mainRoom || !strings.Contains(guestOutput.String(), "Incorrect password for the room accessRoom.") {
+		t.Fatalf("expected the incorrect password to be rejected. Actual output %s", guestOutput.String())
+	}
+	guest.handleInput("-r accessRoom", selectedRoom)
+	selectedRoom, _ = guest.handleInput("secret", selectedRoom)
+	if selectedRoom != room || !room.HasUser(guest.Name) {
+		t.Fatal("expected the guest to enter the room with the password")
+	}
+}
+
+func TestHandleRoomRequest_GetMessages_InviteOnly(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	room, err := server.OpenRoom("leads", "owner", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.SetModes(true, true)
+	room.Invite("tester")
+	for _, userName := range []string{"tester", "outsider"} {
+		if err = server.RegisterAccount(userName, "password1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}", RoomRequestHandler)
+	for userName, expectedStatus := range map[string]int{"tester": http.StatusOK, "outsider": http.StatusForbidden} {
+		req, err := http.NewRequest(http.MethodGet, "/rooms/leads", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth(userName, "password1")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if status := rr.Code; status != expectedStatus {
+			t.Errorf("handler returned wrong status code for %s: got %v want %v", userName, status, expectedStatus)
+		}
+	}
+	//
+	// The name of an invited user alone does not identify the requester
+	//
+	req, err := http.NewRequest(http.MethodGet, "/rooms/leads", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Sender-Name", "tester")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code for an unauthenticated name: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
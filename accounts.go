@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"time"
+)
+
+const (
+	pathAccounts          = "/accounts"
+	headerAuthenticate    = "WWW-Authenticate"
+	authenticateChallenge = `Basic realm="watercooler"`
+	minPasswordLength     = 8
+)
+
+var (
+	errAccountExists      = errors.New("the name is already registered")
+	errNameInUse          = errors.New("the name is in use by another user")
+	errPasswordTooShort   = errors.New("the password is too short")
+	errInvalidCredentials = errors.New("invalid name or password")
+)
+
+// Account is a registered user name. Only those who know the account's password can use the name.
+type Account struct {
+	Name         string    `json:"name"`
+	PasswordHash []byte    `json:"passwordHash"`
+	Created      time.Time `json:"created"`
+}
+
+// accountRequest is the body of a HTTP request to register an account.
+type accountRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// RegisterAccount registers the name so the password is required to use it. The password is stored as a salted hash.
+func (server *ChatServer) RegisterAccount(userName string, password string) error {
+	if len(password) < minPasswordLength {
+		return errors.Wrapf(errPasswordTooShort, "failed to register %s", userName)
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrapf(err, "failed to hash the password of %s", userName)
+	}
+	server.accountsLock.Lock()
+	if server.accounts[userName] != nil {
+		server.accountsLock.Unlock()
+		return errors.Wrapf(errAccountExists, "failed to register %s", userName)
+	}
+	server.accounts[userName] = &Account{
+		Name:         userName,
+		PasswordHash: passwordHash,
+		Created:      time.Now(),
+	}
+	server.accountsLock.Unlock()
+	logger.Printf("%s has been registered\n", userName)
+	server.saveState()
+	return nil
+}
+
+// HasAccount checks if the name has been registered.
+func (server *ChatServer) HasAccount(userName string) bool {
+	server.accountsLock.RLock()
+	defer server.accountsLock.RUnlock()
+	return server.accounts[userName] != nil
+}
+
+// Authenticate checks the password matches the password of the name's account.
+func (server *ChatServer) Authenticate(userName string, password string) error {
+	server.accountsLock.RLock()
+	account := server.accounts[userName]
+	server.accountsLock.RUnlock()
+	if account == nil {
+		return errors.Wrapf(errInvalidCredentials, "%s is not registered", userName)
+	}
+	if err := bcrypt.CompareHashAndPassword(account.PasswordHash, []byte(password)); err != nil {
+		return errors.Wrapf(errInvalidCredentials, "incorrect password for %s", userName)
+	}
+	return nil
+}
+
+// AccountsHandler handles registering an account. The name must not be in use by a user on the server.
+func AccountsHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	logger.Println("Received HTTP request to register an account")
+	var body accountRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil || len(body.Name) == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Println("ERROR: HTTP account request missing a name")
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"Body must contain a 'name' and 'password'"}`)
+		return
+	}
+	//
+	// A guest using the name must register the name from their own session
+	//
+	var err error
+	if server.UserExists(body.Name) {
+		err = errNameInUse
+	} else {
+		err = server.RegisterAccount(body.Name, body.Password)
+	}
+	switch errors.Cause(err) {
+	case nil:
+		writer.WriteHeader(http.StatusCreated)
+		writeHttpMessage(writer, `{"statusCode":"201", "reason":"Account successfully registered."}`)
+		logger.Println("Registered account over HTTP for " + body.Name)
+	case errPasswordTooShort:
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Printf("ERROR: HTTP account request for %s has a password that is too short\n", body.Name)
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"Password must be at least 8 characters"}`)
+	case errAccountExists, errNameInUse:
+		writer.WriteHeader(http.StatusConflict)
+		logger.Printf("ERROR: HTTP account request for %s that is already registered or in use\n", body.Name)
+		writeHttpMessage(writer, `{"statusCode":"409", "reason":"The name is already registered or in use"}`)
+	default:
+		writer.WriteHeader(http.StatusInternalServerError)
+		logger.Printf("ERROR: failed to register account: %+v\n", err)
+		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to register account"}`)
+	}
+}
+
+// getSenderName determines the name of the user sending the request from the bearer token or the basic authentication
+// credentials. The 'Sender-Name' header is only used when the server allows it. If the sender cannot be determined or is
+// not authorized, an error response is written and false is returned.
+func getSenderName(writer http.ResponseWriter, request *http.Request) (string, bool) {
+	senderName, ok := authenticateSender(writer, request, scopePost, request.Header.Get(headerSenderName))
+	if ok && len(senderName) == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Println("ERROR: HTTP request missing 'Sender-Name'")
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"Missing Header 'Sender-Name'"}`)
+		return "", false
+	}
+	return senderName, ok
+}
+
+// authenticateSender determines the name of the user sending the request. The name is taken from the bearer token granting
+// the scope, then the basic authentication credentials. Without either, the name claimed by the request is only used when
+// the server allows the 'Sender-Name' header, and a blank name is returned if none was claimed. If the sender is not
+// authorized, an error response is written and false is returned.
+func authenticateSender(writer http.ResponseWriter, request *http.Request, scope string, claimedName string) (string, bool) {
+	token, ok := authenticateToken(writer, request, scope)
+	if !ok {
+		return "", false
+	} else if token != nil {
+		return token.Name, true
+	} else if server.TokensRequired() {
+		writeTokenRequired(writer, `{"statusCode":"401", "reason":"A token is required"}`)
+		return "", false
+	}
+	if authName, _, ok := request.BasicAuth(); ok {
+		return authName, authorizeUser(writer, request, authName, scope)
+	}
+	if !server.SenderNameAllowed() {
+		writeCredentialsRequired(writer, `{"statusCode":"401", "reason":"A token or credentials are required"}`)
+		return "", false
+	}
+	if len(claimedName) == 0 {
+		return "", true
+	}
+	return claimedName, authorizeUser(writer, request, claimedName, scope)
+}
+
+// writeCredentialsRequired writes the response for a request that must be authenticated with a token or the credentials of
+// a registered name.
+func writeCredentialsRequired(writer http.ResponseWriter, message string) {
+	writer.Header().Set(headerAuthenticate, authenticateChallenge)
+	writer.WriteHeader(http.StatusUnauthorized)
+	logger.Println("ERROR: HTTP request is not authenticated")
+	writeHttpMessage(writer, message)
+}
+
+// authorizeUser checks the request is allowed to act as the user. A bearer token must belong to the user and grant the
+// scope. Otherwise, registered names require the account's credentials with basic authentication. Credentials that are
+// provided are always checked. If the request is not authorized, an error response is written and false is returned.
+func authorizeUser(writer http.ResponseWriter, request *http.Request, userName string, scope string) bool {
+	token, ok := authenticateToken(writer, request, scope)
+	if !ok {
+		return false
+	} else if token != nil && token.Name != userName {
+		writer.WriteHeader(http.StatusForbidden)
+		logger.Printf("ERROR: token %s cannot act as %s\n", token.ID, userName)
+		writeHttpMessage(writer, `{"statusCode":"403", "reason":"Token does not belong to the user"}`)
+		return false
+	} else if token != nil {
+		return true
+	} else if server.TokensRequired() {
+		writeTokenRequired(writer, `{"statusCode":"401", "reason":"A token is required"}`)
+		return false
+	}
+	authName, password, ok := request.BasicAuth()
+	if !ok && !server.HasAccount(userName) {
+		return true
+	}
+	if ok && authName == userName && server.Authenticate(userName, password) == nil {
+		return true
+	}
+	writer.Header().Set(headerAuthenticate, authenticateChallenge)
+	writer.WriteHeader(http.StatusUnauthorized)
+	logger.Printf("ERROR: HTTP request is not authorized to act as %s\n", userName)
+	writeHttpMessage(writer, `{"statusCode":"401", "reason":"Valid credentials are required for the name"}`)
+	return false
+}
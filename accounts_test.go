@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChatServer_RegisterAccount(t *testing.T) {
+	server := CreateServer()
+	if err := server.RegisterAccount("tester", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	if !server.HasAccount("tester") {
+		t.Fatal("expected 'tester' to have an account")
+	}
+	if err := server.Authenticate("tester", "password1"); err != nil {
+		t.Fatalf("expected password to be accepted: %+v", err)
+	}
+	if err := server.Authenticate("tester", "password2"); errors.Cause(err) != errInvalidCredentials {
+		t.Fatalf("expected incorrect password to be rejected. Actual error %+v", err)
+	}
+	if err := server.RegisterAccount("tester", "password2"); errors.Cause(err) != errAccountExists {
+		t.Fatalf("expected registering 'tester' again to fail. Actual error %+v", err)
+	}
+	if err := server.RegisterAccount("tester1", "short"); errors.Cause(err) != errPasswordTooShort {
+		t.Fatalf("expected short password to be rejected. Actual error %+v", err)
+	}
+}
+
+func TestAccountsHandler_Register(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	router := mux.NewRouter()
+	router.HandleFunc("/accounts", AccountsHandler)
+	//
+	// Register the name, then attempt to register it again
+	//
+	body := `{"name":"tester","password":"password1"}`
+	req, err := http.NewRequest(http.MethodPost, "/accounts", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	req, err = http.NewRequest(http.MethodPost, "/accounts", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+}
+
+func TestHandleRoomRequest_PostMessage_RegisteredName(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	if err := server.RegisterAccount("tester", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}", RoomRequestHandler)
+	//
+	// The name alone is not enough to send as a registered user
+	//
+	req, err := http.NewRequest(http.MethodPost, "/rooms/main", bytes.NewBufferString("Message posted from test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Sender-Name", "tester")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+	req, err = http.NewRequest(http.MethodPost, "/rooms/main", bytes.NewBufferString("Message posted from test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("tester", "password1")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
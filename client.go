@@ -0,0 +1,42 @@
+package main
+
+import "github.com/piszmog/watercooler-chat/message"
+
+const (
+	eventNotice    = "notice"
+	eventBroadcast = "broadcast"
+	eventMessage   = "message"
+	eventDirect    = "direct"
+	eventMention   = "mention"
+	eventEdit      = "edit"
+	eventDelete    = "delete"
+	eventReaction  = "reaction"
+)
+
+// clientEvent is something that happened on the server that a user is notified of.
+type clientEvent struct {
+	Type    string               `json:"type"`
+	Room    string               `json:"room,omitempty"`
+	Value   string               `json:"value,omitempty"`
+	Message *message.ChatMessage `json:"message,omitempty"`
+}
+
+// chatClient delivers events to a user connected over a protocol other than TELNET. Clients decide how each event is
+// rendered for the protocol.
+type chatClient interface {
+	send(event clientEvent) error
+}
+
+// text renders the event as the line of text written to TELNET users.
+func (event clientEvent) text() string {
+	if event.Type == eventMessage && event.Message != nil {
+		return event.Message.RoomMessage()
+	} else if event.Type == eventDirect && event.Message != nil {
+		return event.Message.DirectMessage()
+	} else if event.Type == eventMention && event.Message != nil {
+		return event.Message.MentionMessage()
+	} else if (event.Type == eventEdit || event.Type == eventDelete) && event.Message != nil {
+		return event.Message.RoomMessage()
+	}
+	return event.Value
+}
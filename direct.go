@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
+	"net/http"
+	"time"
+)
+
+const (
+	pathUserMessages = "/users/{name}/messages"
+	parameterWith    = "with"
+)
+
+var errUserNotFound = errors.New("user is not on the server")
+
+// SendDirectMessage delivers the message to the message's recipient, regardless of the room the recipient is in. The
+// message is kept in the history of the conversation between the sender and recipient. If the recipient has blocked the
+// sender, the message is dropped. If the recipient is away, the sender is sent the reason as a reply from the recipient.
+func (server *ChatServer) SendDirectMessage(chatMessage message.ChatMessage) error {
+	recipient := server.GetUser(chatMessage.Recipient)
+	if recipient == nil {
+		return errors.Wrapf(errUserNotFound, "failed to send direct message to %s", chatMessage.Recipient)
+	}
+	if recipient.IsBlocked(chatMessage.Sender) {
+		logger.Printf("%s has blocked %s. Dropping direct message\n", chatMessage.Recipient, chatMessage.Sender)
+		return nil
+	}
+	chatMessage, err := server.direct.Append(chatMessage)
+	if err != nil {
+		return errors.Wrapf(err, "failed to add direct message to the history of %s and %s", chatMessage.Sender, chatMessage.Recipient)
+	}
+	logger.Println(chatMessage.LogMessage())
+	recipient.ReceiveDirectMessage(chatMessage)
+	if reason, ok := recipient.autoReply(chatMessage.Sender); ok {
+		server.sendAutoReply(recipient.Name, chatMessage.Sender, reason)
+	}
+	return nil
+}
+
+// sendAutoReply lets the sender know the recipient is away. The reply is kept in the history of the conversation so HTTP
+// senders can retrieve it.
+func (server *ChatServer) sendAutoReply(recipientName string, senderName string, reason string) {
+	reply := message.ChatMessage{
+		Timestamp: time.Now(),
+		Sender:    recipientName,
+		Recipient: senderName,
+		Value:     fmt.Sprintf("[auto-reply] I am away: %s", reason),
+	}
+	reply, err := server.direct.Append(reply)
+	if err != nil {
+		logger.Printf("ERROR: failed to add auto-reply to the history of %s and %s: %+v\n", recipientName, senderName, err)
+	}
+	if sender := server.GetUser(senderName); sender != nil {
+		sender.ReceiveDirectMessage(reply)
+	}
+}
+
+// GetDirectMessages retrieves the direct messages between the two users based on the provided query.
+func (server *ChatServer) GetDirectMessages(userName string, otherUserName string, query message.Query) ([]message.ChatMessage, error) {
+	query.RoomName = message.ConversationName(userName, otherUserName)
+	messages, err := server.direct.Query(query)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query the direct messages between %s and %s", userName, otherUserName)
+	}
+	return messages, nil
+}
+
+// UserMessagesHandler handles sending a direct message to the user in the path, and querying the direct messages between
+// the user in the path and the user in the 'with' parameter.
+func UserMessagesHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	userName := mux.Vars(request)[pathVariableName]
+	if request.Method == http.MethodGet {
+		getDirectMessages(request, writer, userName)
+	} else {
+		sendHTTPDirectMessage(writer, request, userName)
+	}
+}
+
+func getDirectMessages(request *http.Request, writer http.ResponseWriter, userName string) {
+	logger.Println("Received HTTP request to get direct messages")
+	otherUserName := request.FormValue(parameterWith)
+	if len(otherUserName) == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Println("ERROR: HTTP GET request missing 'with'")
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"Missing parameter 'with'"}`)
+		return
+	}
+	//
+	// Only the user can read their conversations, so the user must be authenticated with a token or the credentials of a
+	// registered name
+	//
+	if requesterName, ok := authenticateRequester(writer, request, scopeRead); !ok {
+		return
+	} else if len(requesterName) == 0 {
+		writeCredentialsRequired(writer, `{"statusCode":"401", "reason":"A token or the credentials of the user are required"}`)
+		return
+	}
+	if !authorizeUser(writer, request, userName, scopeRead) {
+		return
+	}
+	query, ok := parseQuery(request, writer)
+	if !ok {
+		return
+	}
+	messages, err := server.GetDirectMessages(userName, otherUserName, query)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		logger.Printf("ERROR: failed to query direct messages: %+v\n", err)
+		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to query messages"}`)
+		return
+	}
+	writeNextCursor(writer, query, messages)
+	if writeMessages(writer, messages) {
+		logger.Println("Sent direct messages to HTTP client")
+	}
+}
+
+func sendHTTPDirectMessage(writer http.ResponseWriter, request *http.Request, recipientName string) {
+	//
+	// Determine who is sending the message
+	//
+	senderName, ok := getSenderName(writer, request)
+	if !ok {
+		return
+	}
+	logger.Println("Received HTTP request to send a direct message from user " + senderName)
+	msg, ok := readHTTPMessage(writer, request)
+	if !ok {
+		return
+	}
+	err := server.SendDirectMessage(message.ChatMessage{
+		Timestamp: time.Now(),
+		Sender:    senderName,
+		Recipient: recipientName,
+		Value:     msg,
+	})
+	if errors.Cause(err) == errUserNotFound {
+		writer.WriteHeader(http.StatusNotFound)
+		logger.Printf("ERROR: HTTP direct message recipient %s is not on the server\n", recipientName)
+		writeHttpMessage(writer, `{"statusCode":"404", "reason":"User is not on the server"}`)
+		return
+	} else if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		logger.Printf("ERROR: failed to send direct message: %+v\n", err)
+		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to send message"}`)
+		return
+	}
+	writeSentResponse(writer, request)
+	logger.Println("Sent HTTP direct message to " + recipientName + " from user " + senderName)
+}
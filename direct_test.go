@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatServer_SendDirectMessage(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var b bytes.Buffer
+	server.AddUser(&ChatUser{Name: "tester1", writer: &b})
+	err := server.SendDirectMessage(message.ChatMessage{
+		Timestamp: time.Date(2019, 1, 1, 1, 1, 1, 0, time.UTC),
+		Sender:    "tester",
+		Recipient: "tester1",
+		Value:     "Hello from a test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "[01:01 UTC tester -> tester1]: Hello from a test\n" {
+		t.Fatalf("recipient did not receive the direct message. Actual value %s", b.String())
+	}
+	messages, err := server.GetDirectMessages("tester1", "tester", message.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatal("direct message was not added to the conversation history")
+	}
+}
+
+func TestChatServer_SendDirectMessage_blocked(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var b bytes.Buffer
+	server.AddUser(&ChatUser{Name: "tester1", writer: &b, blockedUsers: map[string]bool{"tester": true}})
+	err := server.SendDirectMessage(message.ChatMessage{Timestamp: time.Now(), Sender: "tester", Recipient: "tester1", Value: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 0 {
+		t.Fatal("blocked sender's direct message was delivered")
+	}
+}
+
+func TestChatServer_SendDirectMessage_userNotFound(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	err := server.SendDirectMessage(message.ChatMessage{Timestamp: time.Now(), Sender: "tester", Recipient: "tester1", Value: "Hello"})
+	if errors.Cause(err) != errUserNotFound {
+		t.Fatalf("expected user not found error. Actual error %+v", err)
+	}
+}
+
+func TestUserMessagesHandler_PostAndGet(t *testing.T) {
+	server = CreateServer()
+	server.AllowSenderName(true)
+	defer func() {
+		server = CreateServer()
+	}()
+	server.AddUser(&ChatUser{Name: "tester1", writer: &bytes.Buffer{}})
+	router := mux.NewRouter()
+	router.HandleFunc("/users/{name}/messages", UserMessagesHandler)
+	req, err := http.NewRequest(http.MethodPost, "/users/tester1/messages", bytes.NewBufferString("Direct message from test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Sender-Name", "tester")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	//
+	// Only the user can read the conversation
+	//
+	if err = server.RegisterAccount("tester1", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, credentials := range [][]string{nil, {"tester", "password1"}} {
+		req, err = http.NewRequest(http.MethodGet, "/users/tester1/messages?with=tester", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if credentials != nil {
+			req.SetBasicAuth(credentials[0], credentials[1])
+		}
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+		}
+	}
+	req, err = http.NewRequest(http.MethodGet, "/users/tester1/messages?with=tester", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("tester1", "password1")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), `"recipient": "tester1"`) {
+		t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
+	}
+}
+
+func TestUserMessagesHandler_PostUserNotFound(t *testing.T) {
+	server = CreateServer()
+	server.AllowSenderName(true)
+	defer func() {
+		server = CreateServer()
+	}()
+	req, err := http.NewRequest(http.MethodPost, "/users/tester1/messages", bytes.NewBufferString("Direct message from test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Sender-Name", "tester")
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/users/{name}/messages", UserMessagesHandler)
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	commandEdit     = "-edit"
+	commandDelete   = "-delete"
+	pathRoomMessage = "/rooms/{name}/messages/{id}"
+)
+
+var (
+	errNotSender      = errors.New("only the sender of a message or a moderator can change the message")
+	errMessageDeleted = errors.New("message has been deleted")
+)
+
+// GetMessage retrieves the message with the ID from the room's history.
+func (room *ChatRoom) GetMessage(id int64) (message.ChatMessage, error) {
+	chatMessage, err := room.store.Get(room.Name, id)
+	if err != nil {
+		return chatMessage, errors.Wrapf(err, "failed to get message %d of room %s", id, room.Name)
+	}
+	return chatMessage, nil
+}
+
+// EditMessage changes the value of the message with the ID. Only the sender of the message and moderators of the room can
+// edit the message.
+func (room *ChatRoom) EditMessage(id int64, value string, editorName string) (message.ChatMessage, error) {
+	return room.amendMessage(message.Amendment{ID: id, Time: time.Now(), Value: value}, editorName)
+}
+
+// DeleteMessage removes the value of the message with the ID from the room's history. Only the sender of the message and
+// moderators of the room can delete the message.
+func (room *ChatRoom) DeleteMessage(id int64, deleterName string) (message.ChatMessage, error) {
+	return room.amendMessage(message.Amendment{ID: id, Time: time.Now(), Deleted: true}, deleterName)
+}
+
+func (room *ChatRoom) amendMessage(amendment message.Amendment, userName string) (message.ChatMessage, error) {
+	chatMessage, err := room.GetMessage(amendment.ID)
+	if err != nil {
+		return chatMessage, err
+	}
+	if !amendment.IsReaction() && chatMessage.Sender != userName && !room.IsModerator(userName) {
+		return chatMessage, errors.Wrapf(errNotSender, "%s cannot change message %d of room %s", userName, amendment.ID, room.Name)
+	} else if chatMessage.Deleted {
+		return chatMessage, errors.Wrapf(errMessageDeleted, "failed to change message %d of room %s", amendment.ID, room.Name)
+	}
+	chatMessage, err = room.store.Amend(room.Name, amendment)
+	if err != nil {
+		return chatMessage, errors.Wrapf(err, "failed to change message %d of room %s", amendment.ID, room.Name)
+	}
+	logger.Printf("%s changed message %d of room %s\n", userName, amendment.ID, room.Name)
+	//
+	// Let subscribers and the other users in the room know of the change
+	//
+	event := clientEvent{Type: eventEdit, Room: room.Name, Message: &chatMessage}
+	if chatMessage.Deleted {
+		event.Type = eventDelete
+		room.Unpin(chatMessage.ID)
+	} else if amendment.IsReaction() {
+		event.Type = eventReaction
+		event.Value = describeReaction(amendment, chatMessage)
+	}
+	room.publish(event)
+	for _, name := range room.GetUsers() {
+		if name == userName {
+			continue
+		}
+		otherUser := server.GetUser(name)
+		if otherUser == nil || otherUser.IsBlocked(chatMessage.Sender) || (amendment.IsReaction() && otherUser.IsBlocked(userName)) {
+			continue
+		}
+		otherUser.receive(event)
+	}
+	return chatMessage, nil
+}
+
+// changeMessage edits or deletes the message with the ID in the message.
+func (user *ChatUser) changeMessage(command string, msg string, room *ChatRoom) {
+	parts := strings.SplitN(msg, " ", 3)
+	if len(parts) < 2 || (command == commandEdit && (len(parts) < 3 || len(strings.TrimSpace(parts[2])) == 0)) {
+		if command == commandEdit {
+			user.ReceiveMessage("A message ID and the new message are required.")
+		} else {
+			user.ReceiveMessage("A message ID is required.")
+		}
+		return
+	}
+	id, err := parseMessageID(parts[1])
+	if err != nil {
+		user.ReceiveMessage(fmt.Sprintf("%s is not a message ID.", parts[1]))
+		return
+	}
+	if !user.isInRoom(room) {
+		return
+	}
+	var chatMessage message.ChatMessage
+	if command == commandEdit {
+		chatMessage, err = room.EditMessage(id, parts[2], user.Name)
+	} else {
+		chatMessage, err = room.DeleteMessage(id, user.Name)
+	}
+	if err != nil {
+		user.receiveMessageError(id, err)
+		return
+	}
+	user.ReceiveMessage(chatMessage.RoomMessage())
+}
+
+// receiveMessageError lets the user know why the message with the ID could not be used. Nothing is written if there is no
+// error.
+func (user *ChatUser) receiveMessageError(id int64, err error) {
+	switch errors.Cause(err) {
+	case nil:
+	case message.ErrMessageNotFound:
+		user.ReceiveMessage(fmt.Sprintf("Message #%d does not exist in the room.", id))
+	case errNotSender:
+		user.ReceiveMessage("Only the sender of a message or a moderator can change the message.")
+	case errMessageDeleted:
+		user.ReceiveMessage(fmt.Sprintf("Message #%d has been deleted.", id))
+	default:
+		logger.Printf("ERROR: failed to use message %d for %s: %+v\n", id, user.Name, err)
+		user.ReceiveMessage(fmt.Sprintf("Failed to use message #%d.", id))
+	}
+}
+
+// parseMessageID parses the ID of a message, as shown to users such as '#12', or as a number.
+func parseMessageID(value string) (int64, error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(value, "#"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.Errorf("invalid message ID %s", value)
+	}
+	return id, nil
+}
+
+// RoomMessageHandler handles editing the message in the path with the body of the request, and deleting the message. Only
+// the sender of the message and moderators of the room can change the message.
+func RoomMessageHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	variables := mux.Vars(request)
+	roomName := variables[pathVariableName]
+	logger.Printf("Received HTTP request to change message %s of room %s\n", variables[pathVariableID], roomName)
+	id, ok := parseMessageIDVariable(writer, variables)
+	if !ok {
+		return
+	}
+	userName, ok := getSenderName(writer, request)
+	if !ok {
+		return
+	}
+	room := server.FindRoom(roomName)
+	if room == nil {
+		writeRoomNotFound(writer, roomName)
+		return
+	}
+	var chatMessage message.ChatMessage
+	var err error
+	if request.Method == http.MethodDelete {
+		chatMessage, err = room.DeleteMessage(id, userName)
+	} else {
+		value, ok := readHTTPMessage(writer, request)
+		if !ok {
+			return
+		}
+		if len(strings.TrimSpace(value)) == 0 {
+			writer.WriteHeader(http.StatusBadRequest)
+			logger.Println("ERROR: HTTP request to edit a message has an empty body")
+			writeHttpMessage(writer, `{"statusCode":"400", "reason":"Body must be the new message"}`)
+			return
+		}
+		chatMessage, err = room.EditMessage(id, value, userName)
+	}
+	if err != nil {
+		writeMessageError(writer, roomName, id, err)
+		return
+	}
+	writeJSON(writer, http.StatusOK, chatMessage)
+}
+
+// parseMessageIDVariable parses the message ID in the path. If the ID is not a positive number, a bad request response is
+// written and false is returned.
+func parseMessageIDVariable(writer http.ResponseWriter, variables map[string]string) (int64, bool) {
+	id, err := parseMessageID(variables[pathVariableID])
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Printf("ERROR: HTTP request has an invalid message ID: %+v\n", err)
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"The message ID must be a positive number"}`)
+		return 0, false
+	}
+	return id, true
+}
+
+func writeRoomNotFound(writer http.ResponseWriter, roomName string) {
+	writer.WriteHeader(http.StatusNotFound)
+	logger.Printf("ERROR: HTTP request for room %s that does not exist\n", roomName)
+	writeHttpMessage(writer, `{"statusCode":"404", "reason":"The room does not exist"}`)
+}
+
+// writeMessageError writes the response for a message of the room that could not be used.
+func writeMessageError(writer http.ResponseWriter, roomName string, id int64, err error) {
+	switch errors.Cause(err) {
+	case message.ErrMessageNotFound:
+		writer.WriteHeader(http.StatusNotFound)
+		logger.Printf("ERROR: HTTP request for message %d of room %s that does not exist\n", id, roomName)
+		writeHttpMessage(writer, `{"statusCode":"404", "reason":"The message does not exist"}`)
+	case errNotSender:
+		writer.WriteHeader(http.StatusForbidden)
+		logger.Printf("ERROR: HTTP request cannot change message %d of room %s: %+v\n", id, roomName, err)
+		writeHttpMessage(writer, `{"statusCode":"403", "reason":"Only the sender of a message or a moderator can change the message"}`)
+	case errMessageDeleted:
+		writer.WriteHeader(http.StatusGone)
+		logger.Printf("ERROR: HTTP request for message %d of room %s that has been deleted\n", id, roomName)
+		writeHttpMessage(writer, `{"statusCode":"410", "reason":"The message has been deleted"}`)
+	default:
+		writer.WriteHeader(http.StatusInternalServerError)
+		logger.Printf("ERROR: failed to use message %d of room %s: %+v\n", id, roomName, err)
+		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to use the message"}`)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatRoom_EditMessage(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var senderOutput, otherOutput bytes.Buffer
+	server.AddUser(&ChatUser{Name: "editSender", writer: &senderOutput})
+	server.AddUser(&ChatUser{Name: "editOther", writer: &otherOutput})
+	room := server.CreateRoomIfMissing("editRoom")
+	room.AddUser("editSender")
+	room.AddUser("editOther")
+	room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "editRoom", Sender: "editSender", Value: "Helo"})
+	if !strings.Contains(otherOutput.String(), "#1 editSender]: Helo") {
+		t.Fatalf("expected the message to be shown with its ID. Actual output %s", otherOutput.String())
+	}
+	if _, err := room.EditMessage(1, "Hacked", "editOther"); errors.Cause(err) != errNotSender {
+		t.Fatalf("expected only the sender to edit the message. Actual error %+v", err)
+	}
+	edited, err := room.EditMessage(1, "Hello", "editSender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Value != "Hello" || edited.Edited == nil {
+		t.Fatalf("expected the message to be edited. Actual message %+v", edited)
+	}
+	if !strings.Contains(otherOutput.String(), "#1 editSender]: Hello (edited)") {
+		t.Fatalf("expected the edit to be sent to the room. Actual output %s", otherOutput.String())
+	}
+	room.SetModerator("editOther", true)
+	if _, err = room.DeleteMessage(1, "editOther"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = room.EditMessage(1, "Hello again", "editSender"); errors.Cause(err) != errMessageDeleted {
+		t.Fatalf("expected a deleted message to not be edited. Actual error %+v", err)
+	}
+	messages, err := room.GetMessages(message.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || !messages[0].Deleted || len(messages[0].Value) != 0 {
+		t.Fatalf("expected the message to be deleted from the history. Actual messages %+v", messages)
+	}
+}
+
+func TestChatUser_handleInput_edit(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var b bytes.Buffer
+	user := ChatUser{Name: "editTester", writer: &b}
+	server.AddUser(&user)
+	room := server.CreateRoomIfMissing("editCommandRoom")
+	room.AddUser(user.Name)
+	room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "editCommandRoom", Sender: "editTester", Value: "Helo"})
+	user.handleInput("-edit #1 Hello", room)
+	if !strings.Contains(b.String(), "#1 editTester]: Hello (edited)") {
+		t.Fatalf("expected the edited message to be shown. Actual output %s", b.String())
+	}
+	user.handleInput("-delete 2", room)
+	if !strings.Contains(b.String(), "Message #2 does not exist in the room.") {
+		t.Fatalf("expected a missing message to be reported. Actual output %s", b.String())
+	}
+	room.Ban(Ban{Name: user.Name, By: "tester"})
+	user.handleInput("-delete 1", room)
+	if chatMessage, _ := room.GetMessage(1); chatMessage.Deleted {
+		t.Fatal("expected a banned user to not delete their message")
+	}
+}
+
+func TestRoomMessageHandler(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	server.AllowSenderName(true)
+	defer func() {
+		server = CreateServer()
+	}()
+	server.AddUser(&ChatUser{Name: "editHttpTester", writer: &bytes.Buffer{}})
+	room := server.CreateRoomIfMissing("editHttpRoom")
+	room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "editHttpRoom", Sender: "editHttpTester", Value: "Helo"})
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}/messages/{id}", RoomMessageHandler)
+	req, err := http.NewRequest(http.MethodPut, "/rooms/editHttpRoom/messages/1", strings.NewReader("Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(headerSenderName, "editHttpTester")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"value": "Hello"`) {
+		t.Fatalf("expected the message to be edited. Actual code %d and body %s", rr.Code, rr.Body.String())
+	}
+	req, err = http.NewRequest(http.MethodDelete, "/rooms/editHttpRoom/messages/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(headerSenderName, "someoneElse")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected other users to not delete the message. Actual code %d", rr.Code)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	pathRoomEvents            = "/rooms/{name}/events"
+	headerLastEventID         = "Last-Event-ID"
+	headerCacheControl        = "Cache-Control"
+	headerContentTypeEvents   = "text/event-stream"
+	eventStreamKeepAlive      = 15 * time.Second
+	eventStreamMessageEvent   = "message"
+	eventStreamKeepAliveValue = ": keep-alive\n\n"
+)
+
+// EventStreamHandler streams the messages sent to the room as Server-Sent Events. Each event ID is the ID of the message in
+// the room's history, so a client that reconnects with the 'Last-Event-ID' header first receives the messages from the
+// room's history that it missed, up to the 'limit' parameter. Changes to messages are sent as 'edit', 'delete', and 'reaction' events without an
+// ID, so they do not move where the client left off.
+func EventStreamHandler(writer http.ResponseWriter, request *http.Request) {
+	defer closeBody(request.Body)
+	roomName := mux.Vars(request)[pathVariableName]
+	if !authorizeRead(writer, request) {
+		return
+	}
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		writer.Header().Add(headerContentType, headerContentTypeJSON)
+		writer.WriteHeader(http.StatusInternalServerError)
+		logger.Println("ERROR: HTTP response does not support streaming")
+		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Streaming is not supported"}`)
+		return
+	}
+	//
+	// Determine where the client left off
+	//
+	var lastSent int64
+	lastEventID := request.Header.Get(headerLastEventID)
+	if len(lastEventID) != 0 {
+		var err error
+		lastSent, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || lastSent < 0 {
+			writer.Header().Add(headerContentType, headerContentTypeJSON)
+			writer.WriteHeader(http.StatusBadRequest)
+			logger.Printf("ERROR: HTTP event stream request has an invalid 'Last-Event-ID' %s\n", lastEventID)
+			writeHttpMessage(writer, `{"statusCode":"400", "reason":"Header 'Last-Event-ID' must be the ID of a previous event"}`)
+			return
+		}
+	}
+	//
+	// Limit the messages sent from the history the same as when retrieving messages
+	//
+	limit := defaultMessageLimit
+	if value := request.FormValue(parameterLimit); len(value) != 0 {
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 1 {
+			writer.Header().Add(headerContentType, headerContentTypeJSON)
+			writer.WriteHeader(http.StatusBadRequest)
+			logger.Printf("ERROR: HTTP event stream request limit format incorrect: %s\n", value)
+			writeHttpMessage(writer, `{"statusCode":"400", "reason":"Parameter 'limit' must be at least 1"}`)
+			return
+		}
+		limit = count
+	}
+	if limit > maxMessageLimit {
+		limit = maxMessageLimit
+	}
+	room := server.FindRoom(roomName)
+	if room == nil {
+		writer.Header().Add(headerContentType, headerContentTypeJSON)
+		writeRoomNotFound(writer, roomName)
+		return
+	}
+	if !authorizeRoom(writer, request, room) {
+		return
+	}
+	//
+	// Subscribe before reading the history so no messages are missed in between
+	//
+	events, unsubscribe := room.Subscribe()
+	defer unsubscribe()
+	var missedMessages []message.ChatMessage
+	if lastSent != 0 {
+		var err error
+		//
+		// Only the most recent missed messages are sent, oldest first so the event IDs keep increasing
+		//
+		missedMessages, err = room.GetMessages(message.Query{AfterID: lastSent, Limit: limit, Descending: true})
+		if err != nil {
+			writer.Header().Add(headerContentType, headerContentTypeJSON)
+			writer.WriteHeader(http.StatusInternalServerError)
+			logger.Printf("ERROR: failed to query missed messages: %+v\n", err)
+			writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to query messages"}`)
+			return
+		}
+		for i, j := 0, len(missedMessages)-1; i < j; i, j = i+1, j-1 {
+			missedMessages[i], missedMessages[j] = missedMessages[j], missedMessages[i]
+		}
+	}
+	writer.Header().Add(headerContentType, headerContentTypeEvents)
+	writer.Header().Add(headerCacheControl, "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	logger.Printf("Started event stream for room %s\n", roomName)
+	for _, chatMessage := range missedMessages {
+		if err := writeEvent(writer, chatMessage); err != nil {
+			logger.Printf("ERROR: failed to write event: %+v\n", err)
+			return
+		}
+		lastSent = chatMessage.ID
+	}
+	flusher.Flush()
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, open := <-events:
+			if !open {
+				logger.Printf("Room %s closed. Ending event stream\n", roomName)
+				return
+			}
+			chatMessage := *event.Message
+			if event.Type != eventMessage {
+				if err := writeAmendmentEvent(writer, event.Type, chatMessage); err != nil {
+					logger.Printf("ERROR: failed to write event: %+v\n", err)
+					return
+				}
+				flusher.Flush()
+				continue
+			}
+			//
+			// Skip messages already sent from the history. The room stores and publishes messages in the order of their IDs
+			//
+			if chatMessage.ID <= lastSent {
+				continue
+			}
+			if err := writeEvent(writer, chatMessage); err != nil {
+				logger.Printf("ERROR: failed to write event: %+v\n", err)
+				return
+			}
+			lastSent = chatMessage.ID
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(writer, eventStreamKeepAliveValue); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-request.Context().Done():
+			logger.Printf("Event stream client for room %s disconnected\n", roomName)
+			return
+		}
+	}
+}
+
+func writeEvent(writer http.ResponseWriter, chatMessage message.ChatMessage) error {
+	data, err := json.Marshal(chatMessage)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "id: %d\nevent: %s\ndata: %s\n\n", chatMessage.ID, eventStreamMessageEvent, data)
+	return err
+}
+
+// writeAmendmentEvent writes the changed message as an event named by the type of change, such as 'edit'.
+func writeAmendmentEvent(writer http.ResponseWriter, eventName string, chatMessage message.ChatMessage) error {
+	data, err := json.Marshal(chatMessage)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", eventName, data)
+	return err
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventStreamHandler_resume(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	room := server.GetRoom("testRoom")
+	first := time.Date(2019, 1, 1, 1, 1, 1, 0, time.UTC)
+	room.SendMessage(message.ChatMessage{Timestamp: first, Room: "testRoom", Sender: "tester", Value: "first"})
+	room.SendMessage(message.ChatMessage{Timestamp: first.Add(time.Minute), Room: "testRoom", Sender: "tester", Value: "second"})
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}/events", EventStreamHandler)
+	httpServer := httptest.NewServer(router)
+	defer httpServer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, httpServer.URL+"/rooms/testRoom/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Add("Last-Event-ID", "1")
+	//
+	// Wait for the room to handle the messages before resuming
+	//
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		messages, _ := room.GetMessages(message.Query{})
+		if len(messages) == 2 {
+			break
+		} else if time.Since(start) > 5*time.Second {
+			t.Fatal("room did not handle the messages")
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", contentType)
+	}
+	reader := bufio.NewReader(resp.Body)
+	data := readEventData(t, reader)
+	if !strings.Contains(data, `"value":"second"`) {
+		t.Fatalf("expected the missed message. Actual data %s", data)
+	}
+	//
+	// New messages are streamed as they are handled by the room, even when sent with an older timestamp
+	//
+	room.SendMessage(message.ChatMessage{Timestamp: first, Room: "testRoom", Sender: "tester", Value: "third"})
+	data = readEventData(t, reader)
+	if !strings.Contains(data, `"value":"third"`) {
+		t.Fatalf("expected the new message. Actual data %s", data)
+	}
+}
+
+func TestEventStreamHandler_resumeLimit(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	room := server.GetRoom("testRoom")
+	first := time.Date(2019, 1, 1, 1, 1, 1, 0, time.UTC)
+	for index, value := range []string{"first", "second", "third", "fourth"} {
+		room.SendMessage(message.ChatMessage{Timestamp: first.Add(time.Duration(index) * time.Minute), Room: "testRoom", Sender: "tester", Value: value})
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}/events", EventStreamHandler)
+	httpServer := httptest.NewServer(router)
+	defer httpServer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, httpServer.URL+"/rooms/testRoom/events?limit=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Add("Last-Event-ID", "1")
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		messages, _ := room.GetMessages(message.Query{})
+		if len(messages) == 4 {
+			break
+		} else if time.Since(start) > 5*time.Second {
+			t.Fatal("room did not handle the messages")
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	//
+	// Only the most recent missed messages are sent, oldest first
+	//
+	reader := bufio.NewReader(resp.Body)
+	for _, expected := range []string{`"value":"third"`, `"value":"fourth"`} {
+		if data := readEventData(t, reader); !strings.Contains(data, expected) {
+			t.Fatalf("expected the missed message %s. Actual data %s", expected, data)
+		}
+	}
+}
+
+func TestEventStreamHandler_badLastEventID(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	req, err := http.NewRequest(http.MethodGet, "/rooms/testRoom/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Last-Event-ID", "abc")
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}/events", EventStreamHandler)
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func readEventData(t *testing.T, reader *bufio.Reader) string {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			return line
+		}
+	}
+}
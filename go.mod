@@ -4,7 +4,9 @@ go 1.12
 
 require (
 	github.com/gorilla/mux v1.7.3
+	github.com/gorilla/websocket v1.4.2
 	github.com/pkg/errors v0.8.1
 	github.com/reiver/go-oi v1.0.0
 	github.com/reiver/go-telnet v0.0.0-20180421082511-9ff0b2ab096e
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 )
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/piszmog/watercooler-chat/message"
 	"github.com/pkg/errors"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -21,11 +27,23 @@ const (
 	parameterSender       = "sender"
 	parameterStart        = "start"
 	parameterEnd          = "end"
+	parameterWait         = "wait"
+	parameterAfter        = "after"
+	parameterBefore       = "before"
+	parameterLimit        = "limit"
+	parameterOrder        = "order"
+	orderAscending        = "asc"
+	orderDescending       = "desc"
+	headerNextCursor      = "Next-Cursor"
+	maxWait               = 30 * time.Second
+	writeTimeout          = 15 * time.Second
+	defaultMessageLimit   = 100
+	maxMessageLimit       = 1000
 )
 
-// StartHTTPServer start a HTTP server.
+// StartHTTPServer start a HTTP server. The server is started as HTTPS if a certificate and key file are provided.
 func StartHTTPServer(config configuration, done chan bool) {
-	port := config.TelnetPort
+	port := config.HTTPPort
 	if len(port) == 0 {
 		logger.Printf("No HTTP port provided in the configuration file. Using default HTTP port '%s'\n", defaultHTTPPort)
 		port = defaultHTTPPort
@@ -40,28 +58,164 @@ func StartHTTPServer(config configuration, done chan bool) {
 	// Create and start server
 	//
 	srv := createHTTPServer(ipAddress, port)
-	if err := srv.ListenAndServe(); err != nil {
+	onShutdown(func(ctx context.Context) {
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Printf("ERROR: HTTP server did not finish handling requests before shutting down: %+v\n", err)
+		}
+	})
+	//
+	// The HTTP server can use its own certificate, otherwise it shares the TELNET server's certificate
+	//
+	certificateFile, keyFile := config.HTTPCertificateFile, config.HTTPKeyFile
+	if len(certificateFile) == 0 || len(keyFile) == 0 {
+		certificateFile, keyFile = config.CertificateFile, config.KeyFile
+	}
+	var err error
+	if len(certificateFile) != 0 && len(keyFile) != 0 {
+		srv.TLSConfig, err = createTLSConfig(config.HTTPMinTLSVersion, config.HTTPClientCAFile, config.HTTPRequireClientCert)
+		if err != nil {
+			logger.Printf("failed to configure TLS for HTTP server: %+v\n", err)
+			done <- true
+			return
+		}
+		err = srv.ListenAndServeTLS(certificateFile, keyFile)
+	} else {
+		logger.Println("A certificate and key file were not provided. HTTP server will start in unsecured mode.")
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		logger.Printf("failed to start HTTP server at address %s: %+v\n", ipAddress+":"+port, err)
 	}
 	done <- true
 }
 
+// createTLSConfig creates the TLS configuration of the HTTP server. If a client CA file is provided, client certificates
+// are verified against the CA file. Clients without a certificate are only rejected if a client certificate is required.
+func createTLSConfig(minVersion string, clientCAFile string, requireClientCert bool) (*tls.Config, error) {
+	version, err := parseTLSVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{MinVersion: version}
+	if len(clientCAFile) == 0 {
+		return tlsConfig, nil
+	}
+	clientCABytes, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read client CA file %s", clientCAFile)
+	}
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(clientCABytes) {
+		return nil, errors.Errorf("no certificates found in client CA file %s", clientCAFile)
+	}
+	if requireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	logger.Printf("HTTP client certificates will be verified with '%s'\n", clientCAFile)
+	return tlsConfig, nil
+}
+
+// parseTLSVersion converts the version, such as '1.2', to the TLS version. If no version is provided, TLS 1.2 is used.
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, errors.Errorf("unknown TLS version %s: must be 1.0, 1.1, 1.2, or 1.3", version)
+	}
+}
+
 func createHTTPServer(ipAddress, port string) *http.Server {
 	r := mux.NewRouter()
 	//
-	// Setup route the the GET and POST for messages to/from a room
+	// Setup route to list the rooms
+	//
+	r.Handle(pathRooms, withWriteTimeout(RoomsHandler, writeTimeout)).Methods(http.MethodGet)
+	//
+	// Setup routes to create and remove a room, and to list the users in a room
+	//
+	r.Handle(pathRoom, withWriteTimeout(RoomHandler, writeTimeout)).Methods(http.MethodPut, http.MethodDelete)
+	r.Handle(pathRoomUsers, withWriteTimeout(RoomUsersHandler, writeTimeout)).Methods(http.MethodGet)
+	//
+	// Setup routes to see who is on the server
+	//
+	r.Handle(pathUsers, withWriteTimeout(UsersHandler, writeTimeout)).Methods(http.MethodGet)
+	r.Handle(pathUser, withWriteTimeout(UserHandler, writeTimeout)).Methods(http.MethodGet)
+	r.Handle(pathUserMentions, withWriteTimeout(UserMentionsHandler, writeTimeout)).Methods(http.MethodGet)
+	//
+	// Setup route the the GET and POST for messages to/from a room. A GET can wait for new messages, so the GET has longer
+	// to respond
+	//
+	r.Handle(pathRoom, withWriteTimeout(RoomRequestHandler, writeTimeout+maxWait)).Methods(http.MethodGet, http.MethodPost)
+	//
+	// Setup route to edit and delete messages
+	//
+	r.Handle(pathRoomMessage, withWriteTimeout(RoomMessageHandler, writeTimeout)).Methods(http.MethodPut, http.MethodDelete)
+	//
+	// Setup routes to reply to messages and retrieve threads
+	//
+	r.Handle(pathRoomMessageReplies, withWriteTimeout(RoomMessageRepliesHandler, writeTimeout)).Methods(http.MethodPost)
+	r.Handle(pathRoomMessageThread, withWriteTimeout(RoomMessageThreadHandler, writeTimeout)).Methods(http.MethodGet)
+	//
+	// Setup route to add and remove reactions to messages
+	//
+	r.Handle(pathRoomMessageReaction, withWriteTimeout(RoomMessageReactionHandler, writeTimeout)).Methods(http.MethodPut, http.MethodDelete)
+	//
+	// Setup route to list the pinned messages of a room
+	//
+	r.Handle(pathRoomPins, withWriteTimeout(RoomPinsHandler, writeTimeout)).Methods(http.MethodGet)
+	//
+	// Setup route to search the messages of rooms
+	//
+	r.Handle(pathSearch, withWriteTimeout(SearchHandler, writeTimeout)).Methods(http.MethodGet)
+	//
+	// Setup route for clients to join a room over a WebSocket
+	//
+	r.HandleFunc(pathRoomWebSocket, WebSocketHandler).Methods(http.MethodGet)
+	//
+	// Setup route to stream messages from a room as Server-Sent Events
+	//
+	r.HandleFunc(pathRoomEvents, EventStreamHandler).Methods(http.MethodGet)
+	//
+	// Setup route to send direct messages to a user and query them
+	//
+	r.Handle(pathUserMessages, withWriteTimeout(UserMessagesHandler, writeTimeout)).Methods(http.MethodGet, http.MethodPost)
+	//
+	// Setup route to register accounts
+	//
+	r.Handle(pathAccounts, withWriteTimeout(AccountsHandler, writeTimeout)).Methods(http.MethodPost)
+	//
+	// Setup routes for admins to manage tokens
+	//
+	r.Handle(pathTokens, withWriteTimeout(TokensHandler, writeTimeout)).Methods(http.MethodGet, http.MethodPost)
+	r.Handle(pathToken, withWriteTimeout(TokenHandler, writeTimeout)).Methods(http.MethodDelete)
+	//
+	// The server has no write timeout, as WebSockets and event streams write to the client for as long as the client is
+	// connected. Every other route is given its own timeout instead
 	//
-	r.HandleFunc(pathRoom, RoomRequestHandler).Methods(http.MethodGet, http.MethodPost)
 	srv := &http.Server{
-		Addr:         ipAddress + ":" + port,
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
-		Handler:      r,
+		Addr:        ipAddress + ":" + port,
+		ReadTimeout: time.Second * 15,
+		IdleTimeout: time.Second * 60,
+		Handler:     r,
 	}
 	return srv
 }
 
+// withWriteTimeout responds with a 503 if the handler has not finished within the timeout. The response is buffered until the
+// handler finishes, so routes that stream to the client are not wrapped.
+func withWriteTimeout(handler http.HandlerFunc, timeout time.Duration) http.Handler {
+	return http.TimeoutHandler(handler, timeout, `{"statusCode":"503", "reason":"The request timed out"}`)
+}
+
 func RoomRequestHandler(writer http.ResponseWriter, request *http.Request) {
 	//
 	// Always close the request body
@@ -94,11 +248,74 @@ func RoomRequestHandler(writer http.ResponseWriter, request *http.Request) {
 
 func getMessages(request *http.Request, writer http.ResponseWriter, roomName string) {
 	logger.Println("Received HTTP request to get messages")
+	if !authorizeRead(writer, request) {
+		return
+	}
+	//
+	// Build the query from the request parameters
+	//
+	query, ok := parseQuery(request, writer)
+	if !ok {
+		return
+	}
+	query.RoomName = roomName
+	//
+	// Determine how long to wait for a matching message
+	//
+	var wait time.Duration
+	waitSeconds := request.FormValue(parameterWait)
+	if len(waitSeconds) != 0 {
+		seconds, err := strconv.Atoi(waitSeconds)
+		if err != nil || seconds < 0 {
+			writer.WriteHeader(http.StatusBadRequest)
+			logger.Printf("ERROR: HTTP GET request wait format incorrect: %s\n", waitSeconds)
+			writeHttpMessage(writer, `{"statusCode":"400", "reason":"Parameter 'wait' must be a positive number of seconds"}`)
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+		if wait > maxWait {
+			wait = maxWait
+		}
+	}
+	//
+	// Get the room from server
+	//
+	room := server.FindRoom(roomName)
+	if room == nil {
+		writeRoomNotFound(writer, roomName)
+		return
+	}
+	if !authorizeRoom(writer, request, room) {
+		return
+	}
+	//
+	// Get the messages and send back to the HTTP client
+	//
+	var messages []message.ChatMessage
+	var err error
+	if wait > 0 {
+		messages, err = room.WaitForMessages(query, wait, request.Context().Done())
+	} else {
+		messages, err = room.GetMessages(query)
+	}
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		logger.Printf("ERROR: failed to query messages: %+v\n", err)
+		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to query messages"}`)
+		return
+	}
+	writeNextCursor(writer, query, messages)
+	if writeMessages(writer, messages) {
+		logger.Println("Sent room messages to HTTP client")
+	}
+}
+
+// parseQuery builds a query from the 'sender', 'start', 'end', 'after', 'before', 'limit', and 'order' request parameters. If a parameter is in the wrong format, a bad request response is written and false is returned.
+func parseQuery(request *http.Request, writer http.ResponseWriter) (message.Query, bool) {
 	//
 	// Start building the query
 	//
 	query := message.Query{
-		RoomName:   roomName,
 		SenderName: request.FormValue(parameterSender),
 	}
 	//
@@ -111,7 +328,7 @@ func getMessages(request *http.Request, writer http.ResponseWriter, roomName str
 			writer.WriteHeader(http.StatusBadRequest)
 			logger.Printf("ERROR: HTTP GET request start format incorrect: %+v\n", err)
 			writeHttpMessage(writer, `{"statusCode":"400", "reason":"Time format for parameter 'start' is in the incorrect format. Use format 'YYYY-MM-ddTHH:mm:ss.sssZ'"}`)
-			return
+			return query, false
 		}
 		query.Start = startTime
 	}
@@ -125,18 +342,100 @@ func getMessages(request *http.Request, writer http.ResponseWriter, roomName str
 			writer.WriteHeader(http.StatusBadRequest)
 			logger.Printf("ERROR: HTTP GET request end format incorrect: %+v\n", err)
 			writeHttpMessage(writer, `{"statusCode":"400", "reason":"Time format for parameter 'end' is in the incorrect format. Use format 'YYYY-MM-ddTHH:mm:ss.sssZ'"}`)
-			return
+			return query, false
 		}
 		query.End = endTime
 	}
 	//
-	// Get the room from server
+	// Only include messages after or before the cursor if provided
 	//
-	room := server.GetRoom(roomName)
+	var ok bool
+	if query.AfterID, ok = parseMessageIDParameter(writer, request, parameterAfter); !ok {
+		return query, false
+	}
+	if query.BeforeID, ok = parseMessageIDParameter(writer, request, parameterBefore); !ok {
+		return query, false
+	}
 	//
-	// Get the messages and send back to the HTTP client
+	// Always limit the messages so a large history is retrieved a page at a time
 	//
-	responseBytes, err := json.MarshalIndent(room.GetMessages(query), "", "  ")
+	if query.Limit, ok = parseCountParameter(writer, request, parameterLimit, defaultMessageLimit); !ok {
+		return query, false
+	} else if query.Limit == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Println("ERROR: HTTP GET request limit is zero")
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"Parameter 'limit' must be at least 1"}`)
+		return query, false
+	} else if query.Limit > maxMessageLimit {
+		query.Limit = maxMessageLimit
+	}
+	switch order := request.FormValue(parameterOrder); order {
+	case "", orderAscending:
+	case orderDescending:
+		query.Descending = true
+	default:
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Printf("ERROR: HTTP GET request order format incorrect: %s\n", order)
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"Parameter 'order' must be 'asc' or 'desc'"}`)
+		return query, false
+	}
+	return query, true
+}
+
+// parseMessageIDParameter parses the named query parameter as a message ID. If the parameter is missing, zero is returned.
+// If the parameter is not a message ID, an error response is written and false is returned.
+func parseMessageIDParameter(writer http.ResponseWriter, request *http.Request, name string) (int64, bool) {
+	value := request.FormValue(name)
+	if len(value) == 0 {
+		return 0, true
+	}
+	id, err := parseMessageID(value)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Printf("ERROR: HTTP request parameter %s format incorrect: %s\n", name, value)
+		writeHttpMessage(writer, fmt.Sprintf(`{"statusCode":"400", "reason":"Parameter '%s' must be a message ID"}`, name))
+		return 0, false
+	}
+	return id, true
+}
+
+// parseCountParameter parses the named query parameter as a number that is zero or more. If the parameter is missing, the
+// default is returned. If the parameter is not a number, an error response is written and false is returned.
+func parseCountParameter(writer http.ResponseWriter, request *http.Request, name string, defaultCount int) (int, bool) {
+	value := request.FormValue(name)
+	if len(value) == 0 {
+		return defaultCount, true
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Printf("ERROR: HTTP request parameter %s format incorrect: %s\n", name, value)
+		writeHttpMessage(writer, fmt.Sprintf(`{"statusCode":"400", "reason":"Parameter '%s' must be a positive number"}`, name))
+		return 0, false
+	}
+	return count, true
+}
+
+// writeNextCursor lets the client know the message ID to continue from, which is the ID of the last message. If no messages
+// matched, the cursor of the query is kept. The ID is passed as 'after' to the next request, or as 'before' when the
+// messages are newest first. Message IDs follow the order messages are stored in, so long-polling with 'after' does not
+// miss messages, even when they were sent with an older timestamp.
+func writeNextCursor(writer http.ResponseWriter, query message.Query, messages []message.ChatMessage) {
+	cursor := query.AfterID
+	if query.Descending {
+		cursor = query.BeforeID
+	}
+	if len(messages) != 0 {
+		cursor = messages[len(messages)-1].ID
+	}
+	if cursor != 0 {
+		writer.Header().Set(headerNextCursor, strconv.FormatInt(cursor, 10))
+	}
+}
+
+// writeMessages writes the messages to the HTTP client as JSON. Returns true if the messages were written.
+func writeMessages(writer http.ResponseWriter, messages []message.ChatMessage) bool {
+	responseBytes, err := json.MarshalIndent(messages, "", "  ")
 	//
 	// Handle error
 	//
@@ -144,7 +443,7 @@ func getMessages(request *http.Request, writer http.ResponseWriter, roomName str
 		writer.WriteHeader(http.StatusInternalServerError)
 		logger.Printf("ERROR: failed to marshal message response: %+v\n", err)
 		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to serialize response data"}`)
-		return
+		return false
 	}
 	//
 	// Ship the OK response
@@ -153,9 +452,9 @@ func getMessages(request *http.Request, writer http.ResponseWriter, roomName str
 	_, err = writer.Write(responseBytes)
 	if err != nil {
 		logger.Printf("ERROR: failed to write response: %+v\n", err)
-	} else {
-		logger.Println("Sent room messages to HTTP client")
+		return false
 	}
+	return true
 }
 
 func parseTime(timestamp string) (time.Time, error) {
@@ -168,13 +467,10 @@ func parseTime(timestamp string) (time.Time, error) {
 
 func sendHTTPMessage(writer http.ResponseWriter, request *http.Request, roomName string) {
 	//
-	// Get the required header value
+	// Determine who is sending the message
 	//
-	senderName := request.Header.Get(headerSenderName)
-	if len(senderName) == 0 {
-		writer.WriteHeader(http.StatusBadRequest)
-		logger.Println("ERROR: HTTP POST request missing 'Sender-Name'")
-		writeHttpMessage(writer, `{"statusCode":"400", "reason":"Missing Header 'Sender-Name'"}`)
+	senderName, ok := getSenderName(writer, request)
+	if !ok {
 		return
 	}
 	logger.Println("Received HTTP request to send a message from user " + senderName)
@@ -182,12 +478,31 @@ func sendHTTPMessage(writer http.ResponseWriter, request *http.Request, roomName
 		Name: senderName,
 	}
 	//
-	// Lookup the room
+	// Lookup the room, unless the sender cannot enter it
 	//
-	room := server.GetRoom(roomName)
+	room, err := server.OpenRoom(roomName, senderName, hostOf(request.RemoteAddr), request.Header.Get(headerRoomPassword))
+	if err != nil {
+		writeRoomForbidden(writer, senderName, roomName, err)
+		return
+	}
 	//
 	// Get the message to send
 	//
+	msg, ok := readHTTPMessage(writer, request)
+	if !ok {
+		return
+	}
+	//
+	// Send message to room
+	//
+	u.SendMessage(msg, room)
+	writeSentResponse(writer, request)
+	logger.Println("Sent HTTP message to room " + roomName + " from user " + senderName)
+}
+
+// readHTTPMessage reads the message to send from the request body. Messages are truncated to 500 characters. If the body
+// cannot be read, an error response is written and false is returned.
+func readHTTPMessage(writer http.ResponseWriter, request *http.Request) (string, bool) {
 	body := request.Body
 	var buf []byte
 	//
@@ -206,12 +521,14 @@ func sendHTTPMessage(writer http.ResponseWriter, request *http.Request, roomName
 		writer.WriteHeader(http.StatusInternalServerError)
 		logger.Printf("ERROR: failed to handle HTTP POST request: %+v\n", err)
 		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to handle request"}`)
-		return
+		return "", false
 	}
-	//
-	// Send message to room
-	//
-	u.SendMessage(string(buf), room)
+	return string(buf), true
+}
+
+// writeSentResponse writes the response for a message that was successfully sent, letting the client know if the message
+// was truncated.
+func writeSentResponse(writer http.ResponseWriter, request *http.Request) {
 	if request.ContentLength > 500 {
 		writer.WriteHeader(http.StatusOK)
 		logger.Println("WARN: HTTP POST request body greater than 500 bytes. Truncating message")
@@ -219,7 +536,6 @@ func sendHTTPMessage(writer http.ResponseWriter, request *http.Request, roomName
 	} else {
 		writer.WriteHeader(http.StatusOK)
 		writeHttpMessage(writer, `{"statusCode":"200", "reason":"Message successfully sent."}`)
-		logger.Println("Sent HTTP message to room " + roomName + " from user " + senderName)
 	}
 }
 
@@ -2,12 +2,23 @@ package main
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"github.com/gorilla/mux"
 	"github.com/piszmog/watercooler-chat/message"
+	"io/ioutil"
 	"log"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -54,6 +65,7 @@ func TestHandleRoomRequest_GetAllMessages(t *testing.T) {
 	}
 	expected := `[
   {
+    "id": 1,
     "timestamp": "2019-02-01T01:01:01Z",
     "room": "main",
     "sender": "tester",
@@ -154,6 +166,7 @@ func TestHandleRoomRequest_PostMessage(t *testing.T) {
 	// Setup server
 	//
 	server = CreateServer()
+	server.AllowSenderName(true)
 	defer func() {
 		server = CreateServer()
 	}()
@@ -183,6 +196,7 @@ func TestHandleRoomRequest_PostMessage_NoSenderName(t *testing.T) {
 	// Setup server
 	//
 	server = CreateServer()
+	server.AllowSenderName(true)
 	defer func() {
 		server = CreateServer()
 	}()
@@ -205,3 +219,296 @@ func TestHandleRoomRequest_PostMessage_NoSenderName(t *testing.T) {
 		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
 	}
 }
+
+func TestHandleRoomRequest_PostMessage_SenderNameNotAllowed(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	//
+	// Setup HTTP test
+	//
+	req, err := http.NewRequest(http.MethodPost, "/rooms/main", bytes.NewBufferString("Message posted from test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Sender-Name", "tester")
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}", RoomRequestHandler)
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+	if challenge := rr.Header().Get(headerAuthenticate); challenge != authenticateChallenge {
+		t.Errorf("handler returned unexpected challenge: got %v want %v", challenge, authenticateChallenge)
+	}
+}
+
+func TestHandleRoomRequest_GetMessages_Wait(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	room := server.GetRoom("main")
+	room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "main", Sender: "tester", Value: "Already read"})
+	//
+	// Send the message once the request is waiting. The older timestamp must not hide the message
+	//
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		room.SendMessage(message.ChatMessage{
+			Timestamp: time.Date(2019, 2, 1, 1, 1, 1, 0, time.UTC),
+			Room:      "main",
+			Sender:    "tester",
+			Value:     "Hello from HTTP test",
+		})
+	}()
+	req, err := http.NewRequest(http.MethodGet, "/rooms/main?wait=5&after=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}", RoomRequestHandler)
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "Hello from HTTP test") {
+		t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
+	}
+	if cursor := rr.Header().Get(headerNextCursor); cursor != "2" {
+		t.Errorf("handler returned unexpected cursor: got %v want %v", cursor, "2")
+	}
+}
+
+func TestHandleRoomRequest_GetMessages_WaitTimeout(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	server.CreateRoomIfMissing("main")
+	req, err := http.NewRequest(http.MethodGet, "/rooms/main?wait=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}", RoomRequestHandler)
+	start := time.Now()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if time.Since(start) < time.Second {
+		t.Error("handler did not wait for a message")
+	}
+	if rr.Body.String() != "[]" {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), "[]")
+	}
+}
+
+func TestHandleRoomRequest_GetMessages_RoomNotFound(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	req, err := http.NewRequest(http.MethodGet, "/rooms/missingRoom", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}", RoomRequestHandler)
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+	//
+	// Reading a room does not create it
+	//
+	if server.FindRoom("missingRoom") != nil {
+		t.Fatal("expected the room not to be created")
+	}
+}
+
+func TestHandleRoomRequest_GetMessages_BadWait(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	req, err := http.NewRequest(http.MethodGet, "/rooms/main?wait=forever", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}", RoomRequestHandler)
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	expected := `{"statusCode":"400", "reason":"Parameter 'wait' must be a positive number of seconds"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+}
+
+func TestHandleRoomRequest_GetMessages_Page(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	room := server.CreateRoomIfMissing("pageRoom")
+	for i := 0; i < 5; i++ {
+		room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "pageRoom", Sender: "tester", Value: "Hello"})
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}", RoomRequestHandler)
+	req, err := http.NewRequest(http.MethodGet, "/rooms/pageRoom?limit=2&order=desc&before=5", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var messages []message.ChatMessage
+	if err = json.Unmarshal(rr.Body.Bytes(), &messages); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || len(messages) != 2 || messages[0].ID != 4 || messages[1].ID != 3 {
+		t.Fatalf("expected the page of messages before message 5, newest first. Actual code %d and body %s", rr.Code, rr.Body.String())
+	}
+	if cursor := rr.Header().Get(headerNextCursor); cursor != "3" {
+		t.Fatalf("expected the next page to continue before message 3. Actual cursor %s", cursor)
+	}
+	req, err = http.NewRequest(http.MethodGet, "/rooms/pageRoom?limit=3&after=3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"id": 5`) || rr.Header().Get(headerNextCursor) != "5" {
+		t.Fatalf("expected the last page of messages. Actual code %d and body %s", rr.Code, rr.Body.String())
+	}
+	for _, query := range []string{"limit=many", "limit=0", "order=newest", "after=first"} {
+		req, err = http.NewRequest(http.MethodGet, "/rooms/pageRoom?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be a bad request. Actual code %d", query, rr.Code)
+		}
+	}
+}
+
+func TestParseQuery_Limit(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/rooms/main", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query, ok := parseQuery(req, httptest.NewRecorder()); !ok || query.Limit != defaultMessageLimit {
+		t.Fatalf("expected the default limit without a limit. Actual query %+v", query)
+	}
+	req, err = http.NewRequest(http.MethodGet, "/rooms/main?limit=5000", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query, ok := parseQuery(req, httptest.NewRecorder()); !ok || query.Limit != maxMessageLimit {
+		t.Fatalf("expected the limit to be capped. Actual query %+v", query)
+	}
+}
+
+func TestCreateTLSConfig(t *testing.T) {
+	tlsConfig, err := createTLSConfig("", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 || tlsConfig.ClientAuth != tls.NoClientCert {
+		t.Fatalf("unexpected default TLS configuration %+v", tlsConfig)
+	}
+	if _, err = createTLSConfig("2.0", "", false); err == nil {
+		t.Fatal("expected unknown TLS version to fail")
+	}
+}
+
+func TestCreateTLSConfig_ClientCA(t *testing.T) {
+	//
+	// Create a self signed CA for client certificates
+	//
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(caFile.Name())
+	if err = pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: certificate}); err != nil {
+		t.Fatal(err)
+	}
+	caFile.Close()
+	tlsConfig, err := createTLSConfig("1.3", caFile.Name(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS13 || tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("unexpected TLS configuration %+v", tlsConfig)
+	}
+	tlsConfig, err = createTLSConfig("1.2", caFile.Name(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Fatalf("expected client certificates to be optional. Actual client auth %v", tlsConfig.ClientAuth)
+	}
+}
+
+func TestWithWriteTimeout(t *testing.T) {
+	handler := withWriteTimeout(func(writer http.ResponseWriter, request *http.Request) {
+		<-request.Context().Done()
+	}, time.Millisecond*10)
+	req, err := http.NewRequest(http.MethodGet, "/rooms", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+}
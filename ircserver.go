@@ -0,0 +1,564 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ircServerName          = "watercooler"
+	ircChannelPrefix       = "#"
+	ircCommandNick         = "NICK"
+	ircCommandUser         = "USER"
+	ircCommandJoin         = "JOIN"
+	ircCommandPart         = "PART"
+	ircCommandPrivateMsg   = "PRIVMSG"
+	ircCommandNotice       = "NOTICE"
+	ircCommandNames        = "NAMES"
+	ircCommandList         = "LIST"
+	ircCommandPing         = "PING"
+	ircCommandPong         = "PONG"
+	ircCommandQuit         = "QUIT"
+	ircCommandCap          = "CAP"
+	ircCommandPass         = "PASS"
+	ircCommandInvite       = "INVITE"
+	ircCommandTopic        = "TOPIC"
+	ircCommandAway         = "AWAY"
+	ircReplyWelcome        = "001"
+	ircReplyUnaway         = "305"
+	ircReplyNowAway        = "306"
+	ircReplyListStart      = "321"
+	ircReplyList           = "322"
+	ircReplyListEnd        = "323"
+	ircReplyNoTopic        = "331"
+	ircReplyTopic          = "332"
+	ircReplyInviting       = "341"
+	ircReplyNames          = "353"
+	ircReplyEndOfNames     = "366"
+	ircReplyNoMotd         = "422"
+	ircErrorNoSuchNick     = "401"
+	ircErrorUnknownCommand = "421"
+	ircErrorNoNickGiven    = "431"
+	ircErrorNickInUse      = "433"
+	ircErrorNotOnChannel   = "442"
+	ircErrorNotRegistered  = "451"
+	ircErrorNeedMoreParams = "461"
+	ircErrorPasswordWrong  = "464"
+	ircErrorInviteOnlyChan = "473"
+	ircErrorBannedFromChan = "474"
+	ircErrorBadChannelKey  = "475"
+)
+
+// StartIRCServer start an IRC server. The server is only started if an IRC port is provided in the configuration.
+func StartIRCServer(config configuration, done chan bool) {
+	if len(config.IRCPort) == 0 {
+		logger.Println("No IRC port provided in the configuration file. IRC server will not start.")
+		return
+	}
+	ipAddress := config.IPAddress
+	if len(ipAddress) == 0 {
+		logger.Printf("No IP Address provided in the configuration file. Using default IP Address '%s'\n", defaultIPAddress)
+		ipAddress = defaultIPAddress
+	}
+	logger.Printf("Starting IRC server on '%s'...\n", ipAddress+":"+config.IRCPort)
+	listener, err := net.Listen("tcp", ipAddress+":"+config.IRCPort)
+	if err != nil {
+		logger.Printf("failed to start IRC server at address %s: %+v\n", ipAddress+":"+config.IRCPort, err)
+		done <- true
+		return
+	}
+	closeOnShutdown(listener)
+	if err = serveIRC(listener); err != nil {
+		logger.Printf("IRC server at address %s stopped: %+v\n", ipAddress+":"+config.IRCPort, err)
+	}
+	done <- true
+}
+
+// serveIRC accepts IRC connections on the listener until the listener is closed.
+func serveIRC(listener net.Listener) error {
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		client := &ircClient{conn: conn}
+		go client.serve()
+	}
+}
+
+// ircClient is a user connected with an IRC client. IRC channels map to rooms, prefixed with '#'. As users are only in a
+// single room at a time, joining a channel leaves the channel the user was in. The lock guards writing to the connection
+// and the room, as the room is changed by the client while messages are sent to the client by the room.
+type ircClient struct {
+	conn     net.Conn
+	lock     sync.Mutex
+	nick     string
+	userName string
+	password string
+	user     *ChatUser
+	room     *ChatRoom
+}
+
+func (client *ircClient) send(event clientEvent) error {
+	switch event.Type {
+	case eventMessage:
+		return client.writePrivateMessage(event.Message.Sender, ircChannelPrefix+event.Room, event.Message.Value)
+	case eventDirect:
+		return client.writePrivateMessage(event.Message.Sender, client.nick, event.Message.Value)
+	case eventMention:
+		//
+		// Mentions in the channel the user has joined are regular channel messages to IRC clients
+		//
+		if room := client.currentRoom(); room != nil && room.Name == event.Room {
+			return client.writePrivateMessage(event.Message.Sender, ircChannelPrefix+event.Room, event.Message.Value)
+		}
+		return client.writeLines(ircCommandNotice, client.nick, event.Message.MentionMessage())
+	case eventEdit, eventDelete, eventReaction:
+		return client.writeLines(ircCommandNotice, ircChannelPrefix+event.Room, event.text())
+	case eventBroadcast:
+		return client.writeLines(ircCommandNotice, ircChannelPrefix+event.Room, event.Value)
+	default:
+		return client.writeLines(ircCommandNotice, client.nick, event.Value)
+	}
+}
+
+func (client *ircClient) serve() {
+	defer client.conn.Close()
+	logger.Printf("IRC client %s connected\n", client.conn.RemoteAddr())
+	scanner := bufio.NewScanner(client.conn)
+	for scanner.Scan() {
+		command, params := parseIRCMessage(scanner.Text())
+		if len(command) == 0 {
+			continue
+		}
+		if !client.handle(command, params) {
+			break
+		}
+	}
+	//
+	// Handle the user leaving the server
+	//
+	if client.user != nil {
+		if room := client.currentRoom(); room != nil {
+			client.user.leave(room)
+		}
+		server.RemoveUser(client.user.Name)
+	}
+	logger.Printf("IRC client %s disconnected\n", client.conn.RemoteAddr())
+}
+
+// handle handles a single IRC command. Returns false if the client has quit.
+func (client *ircClient) handle(command string, params []string) bool {
+	switch command {
+	case ircCommandCap:
+		// Capability negotiation is not supported - clients continue without capabilities
+	case ircCommandPing:
+		client.writeReply(ircCommandPong, strings.Join(params, " "))
+	case ircCommandPass:
+		if len(params) < 1 {
+			client.writeNumeric(ircErrorNeedMoreParams, ircCommandPass, "Not enough parameters")
+		} else {
+			client.password = params[0]
+		}
+	case ircCommandNick:
+		client.setNick(params)
+	case ircCommandUser:
+		if len(params) < 1 {
+			client.writeNumeric(ircErrorNeedMoreParams, ircCommandUser, "Not enough parameters")
+		} else if client.user == nil {
+			client.userName = params[0]
+			client.register()
+		}
+	case ircCommandQuit:
+		return false
+	default:
+		if client.user == nil {
+			client.writeNumeric(ircErrorNotRegistered, "You have not registered")
+		} else {
+			client.handleRegistered(command, params)
+		}
+	}
+	return true
+}
+
+func (client *ircClient) handleRegistered(command string, params []string) {
+	client.user.markActive()
+	switch command {
+	case ircCommandJoin:
+		if len(params) < 1 {
+			client.writeNumeric(ircErrorNeedMoreParams, ircCommandJoin, "Not enough parameters")
+			return
+		}
+		//
+		// Keys are the passwords of the channels, in the same order as the channels
+		//
+		var keys []string
+		if len(params) > 1 {
+			keys = strings.Split(params[1], ",")
+		}
+		for index, channel := range strings.Split(params[0], ",") {
+			var key string
+			if index < len(keys) {
+				key = keys[index]
+			}
+			client.join(channel, key)
+		}
+	case ircCommandPart:
+		if len(params) < 1 {
+			client.writeNumeric(ircErrorNeedMoreParams, ircCommandPart, "Not enough parameters")
+			return
+		}
+		for _, channel := range strings.Split(params[0], ",") {
+			client.part(channel)
+		}
+	case ircCommandPrivateMsg:
+		if len(params) < 2 {
+			client.writeNumeric(ircErrorNeedMoreParams, ircCommandPrivateMsg, "Not enough parameters")
+			return
+		}
+		client.privateMessage(params[0], params[1])
+	case ircCommandNames:
+		if len(params) < 1 {
+			if room := client.currentRoom(); room != nil {
+				client.names(room)
+			}
+			return
+		}
+		for _, channel := range strings.Split(params[0], ",") {
+			if room := server.FindRoom(strings.TrimPrefix(channel, ircChannelPrefix)); room != nil {
+				client.names(room)
+			} else {
+				client.writeNumeric(ircReplyEndOfNames, channel, "End of /NAMES list")
+			}
+		}
+	case ircCommandList:
+		client.list()
+	case ircCommandTopic:
+		if len(params) < 1 {
+			client.writeNumeric(ircErrorNeedMoreParams, ircCommandTopic, "Not enough parameters")
+			return
+		}
+		client.changeTopic(params)
+	case ircCommandAway:
+		if len(params) < 1 || len(params[0]) == 0 {
+			client.user.setBack()
+			client.writeNumeric(ircReplyUnaway, "You are no longer marked as being away")
+		} else {
+			client.user.setAway(params[0])
+			client.writeNumeric(ircReplyNowAway, "You have been marked as being away")
+		}
+	case ircCommandInvite:
+		if len(params) < 2 {
+			client.writeNumeric(ircErrorNeedMoreParams, ircCommandInvite, "Not enough parameters")
+			return
+		}
+		client.invite(params[0], params[1])
+	default:
+		client.writeNumeric(ircErrorUnknownCommand, command, "Unknown command")
+	}
+}
+
+func (client *ircClient) setNick(params []string) {
+	if len(params) < 1 || len(params[0]) == 0 {
+		client.writeNumeric(ircErrorNoNickGiven, "No nickname given")
+		return
+	}
+	//
+	// Names are owned by the server, so the nick cannot change once registered
+	//
+	if client.user != nil {
+		client.writeNumeric(ircErrorNickInUse, params[0], "Nickname cannot be changed")
+		return
+	}
+	if server.UserExists(params[0]) {
+		client.writeNumeric(ircErrorNickInUse, params[0], "Nickname is already in use")
+		return
+	}
+	client.nick = params[0]
+	client.register()
+}
+
+// register adds the user to the server once both the nick and user name have been provided.
+func (client *ircClient) register() {
+	if len(client.nick) == 0 || len(client.userName) == 0 {
+		return
+	}
+	if server.UserExists(client.nick) {
+		client.writeNumeric(ircErrorNickInUse, client.nick, "Nickname is already in use")
+		client.nick = ""
+		return
+	}
+	//
+	// Registered names require the password to have been sent with PASS
+	//
+	if server.HasAccount(client.nick) {
+		if err := server.Authenticate(client.nick, client.password); err != nil {
+			logger.Printf("ERROR: failed IRC login attempt as %s: %+v\n", client.nick, err)
+			client.writeNumeric(ircErrorPasswordWrong, "Password incorrect")
+			client.nick = ""
+			return
+		}
+	}
+	client.user = &ChatUser{
+		Name:         client.nick,
+		Address:      hostOf(client.conn.RemoteAddr().String()),
+		client:       client,
+		blockedUsers: make(map[string]bool),
+	}
+	server.AddUser(client.user)
+	for _, blockedUser := range server.GetProfile(client.user.Name).BlockedUsers {
+		client.user.block(blockedUser)
+	}
+	client.writeNumeric(ircReplyWelcome, fmt.Sprintf("Welcome to Watercooler Chat %s", client.nick))
+	client.writeNumeric(ircReplyNoMotd, "Join a channel to begin chatting. Use LIST to see existing channels.")
+}
+
+func (client *ircClient) join(channel string, key string) {
+	roomName := strings.TrimPrefix(channel, ircChannelPrefix)
+	if len(roomName) == 0 {
+		return
+	}
+	if current := client.currentRoom(); current != nil && current.Name == roomName && current.HasUser(client.nick) {
+		return
+	}
+	room, err := server.OpenRoom(roomName, client.nick, client.user.Address, key)
+	switch errors.Cause(err) {
+	case nil:
+	case errNotInvited:
+		client.writeNumeric(ircErrorInviteOnlyChan, channel, "Cannot join channel (+i)")
+		return
+	case errRoomPassword:
+		client.writeNumeric(ircErrorBadChannelKey, channel, "Cannot join channel (+k)")
+		return
+	default:
+		client.writeNumeric(ircErrorBannedFromChan, channel, "Cannot join channel (+b)")
+		return
+	}
+	if current := client.currentRoom(); current != nil {
+		client.part(ircChannelPrefix + current.Name)
+	}
+	client.setRoom(room)
+	room.AddUser(client.nick)
+	client.writeMessage(client.nick, ircCommandJoin, ircChannelPrefix+room.Name)
+	client.topic(room)
+	client.names(room)
+}
+
+func (client *ircClient) part(channel string) {
+	roomName := strings.TrimPrefix(channel, ircChannelPrefix)
+	room := client.currentRoom()
+	if room == nil || room.Name != roomName {
+		client.writeNumeric(ircErrorNotOnChannel, channel, "You're not on that channel")
+		return
+	}
+	client.user.leave(room)
+	client.setRoom(nil)
+	client.writeMessage(client.nick, ircCommandPart, channel)
+}
+
+func (client *ircClient) privateMessage(target string, text string) {
+	//
+	// Messages to a channel go to the room, otherwise the message is sent directly to the user
+	//
+	if strings.HasPrefix(target, ircChannelPrefix) {
+		room := client.currentRoom()
+		if room == nil || ircChannelPrefix+room.Name != target || !room.HasUser(client.nick) {
+			client.writeNumeric(ircErrorNotOnChannel, target, "You're not on that channel")
+			return
+		}
+		client.user.SendMessage(text, room)
+		return
+	}
+	err := server.SendDirectMessage(message.ChatMessage{
+		Timestamp: time.Now(),
+		Sender:    client.nick,
+		Recipient: target,
+		Value:     text,
+	})
+	if errors.Cause(err) == errUserNotFound {
+		client.writeNumeric(ircErrorNoSuchNick, target, "No such nick")
+	} else if err != nil {
+		logger.Printf("ERROR: failed to send direct message from IRC client %s: %+v\n", client.nick, err)
+	}
+}
+
+// changeTopic shows the topic of the channel. If a topic is provided, the topic of the channel is changed instead. The
+// client must be on the channel to change the topic.
+func (client *ircClient) changeTopic(params []string) {
+	channel := params[0]
+	if len(params) < 2 {
+		if room := server.FindRoom(strings.TrimPrefix(channel, ircChannelPrefix)); room != nil {
+			client.topic(room)
+		} else {
+			client.writeNumeric(ircReplyNoTopic, channel, "No topic is set")
+		}
+		return
+	}
+	room := client.currentRoom()
+	if room == nil || ircChannelPrefix+room.Name != channel || !room.HasUser(client.nick) {
+		client.writeNumeric(ircErrorNotOnChannel, channel, "You're not on that channel")
+		return
+	}
+	client.user.changeTopic(commandTopic+" "+params[1], room)
+}
+
+func (client *ircClient) topic(room *ChatRoom) {
+	if topic, ok := room.GetTopic(); ok {
+		client.writeNumeric(ircReplyTopic, ircChannelPrefix+room.Name, topic.Value)
+	} else {
+		client.writeNumeric(ircReplyNoTopic, ircChannelPrefix+room.Name, "No topic is set")
+	}
+}
+
+// invite invites the nick to the channel. The client must be on the channel.
+func (client *ircClient) invite(nick string, channel string) {
+	room := client.currentRoom()
+	if room == nil || ircChannelPrefix+room.Name != channel || !room.HasUser(client.nick) {
+		client.writeNumeric(ircErrorNotOnChannel, channel, "You're not on that channel")
+		return
+	}
+	client.user.invite(commandInvite+" "+nick, room)
+	if room.IsInvited(nick) {
+		client.writeNumeric(ircReplyInviting, nick, channel)
+	}
+}
+
+func (client *ircClient) names(room *ChatRoom) {
+	client.writeNumeric(ircReplyNames, "=", ircChannelPrefix+room.Name, strings.Join(room.GetUsers(), " "))
+	client.writeNumeric(ircReplyEndOfNames, ircChannelPrefix+room.Name, "End of /NAMES list")
+}
+
+func (client *ircClient) list() {
+	client.writeNumeric(ircReplyListStart, "Channel", "Users  Name")
+	for _, roomName := range server.ListRooms() {
+		room := server.FindRoom(roomName)
+		if room == nil {
+			continue
+		}
+		topic, _ := room.GetTopic()
+		client.writeNumeric(ircReplyList, ircChannelPrefix+room.Name, strconv.Itoa(len(room.GetUsers())), topic.Value)
+	}
+	client.writeNumeric(ircReplyListEnd, "End of /LIST")
+}
+
+// writeNumeric writes a numeric reply from the server to the client. The last parameter is sent as the trailing parameter.
+func (client *ircClient) writeNumeric(numeric string, params ...string) {
+	nick := client.nick
+	if len(nick) == 0 {
+		nick = "*"
+	}
+	client.writeLine(formatIRCMessage(ircServerName, numeric, append([]string{nick}, params...)...))
+}
+
+// writeReply writes a command from the server to the client.
+func (client *ircClient) writeReply(command string, params ...string) {
+	client.writeLine(formatIRCMessage(ircServerName, command, params...))
+}
+
+// currentRoom is the room of the channel the client has joined, or nil if the client has not joined a channel.
+func (client *ircClient) currentRoom() *ChatRoom {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	return client.room
+}
+
+func (client *ircClient) setRoom(room *ChatRoom) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	client.room = room
+}
+
+// writeMessage writes a command from the specified user to the client.
+func (client *ircClient) writeMessage(sender string, command string, params ...string) error {
+	return client.writeLine(formatIRCMessage(sender+"!"+sender+"@"+ircServerName, command, params...))
+}
+
+// writePrivateMessage writes the text as a PRIVMSG from the specified user to the target, once for every line in the text.
+func (client *ircClient) writePrivateMessage(sender string, target string, text string) error {
+	for _, line := range ircLines(text) {
+		if err := client.writeMessage(sender, ircCommandPrivateMsg, target, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLines writes the text as a command from the server, once for every line in the text.
+func (client *ircClient) writeLines(command string, target string, text string) error {
+	for _, line := range ircLines(text) {
+		if err := client.writeLine(formatIRCMessage(ircServerName, command, target, line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (client *ircClient) writeLine(line string) error {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	_, err := io.WriteString(client.conn, line+"\r\n")
+	if err != nil {
+		logger.Printf("ERROR: failed to write to IRC client %s: %+v\n", client.conn.RemoteAddr(), err)
+	}
+	return err
+}
+
+var ircLineBreaks = strings.NewReplacer("\r", " ", "\n", " ")
+
+// ircLines splits the text into the lines to send to an IRC client. A CR or LF ends an IRC message, so a line must not
+// contain either.
+func ircLines(text string) []string {
+	text = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(text)
+	return strings.Split(strings.TrimRight(text, "\n"), "\n")
+}
+
+// parseIRCMessage parses a line sent by an IRC client into the command and the command's parameters. Any prefix is ignored.
+func parseIRCMessage(line string) (string, []string) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, ":") {
+		index := strings.Index(line, " ")
+		if index < 0 {
+			return "", nil
+		}
+		line = line[index+1:]
+	}
+	var trailing *string
+	if index := strings.Index(line, " :"); index >= 0 {
+		value := line[index+2:]
+		trailing = &value
+		line = line[:index]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	params := fields[1:]
+	if trailing != nil {
+		params = append(params, *trailing)
+	}
+	return strings.ToUpper(fields[0]), params
+}
+
+// formatIRCMessage formats a message to send to an IRC client. The last parameter is sent as the trailing parameter. A CR
+// or LF in a parameter is replaced with a space so the parameter cannot end the message.
+func formatIRCMessage(prefix string, command string, params ...string) string {
+	var builder strings.Builder
+	builder.WriteString(":" + prefix + " " + command)
+	for index, param := range params {
+		builder.WriteString(" ")
+		if index == len(params)-1 {
+			builder.WriteString(":")
+		}
+		builder.WriteString(ircLineBreaks.Replace(param))
+	}
+	return builder.String()
+}
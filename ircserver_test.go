@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeIRC_joinAndReceiveMessage(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveIRC(listener)
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "NICK tester\r\nUSER tester 0 * :Tester\r\n")
+	readIRCLine(t, reader, ":watercooler 001 tester :")
+	fmt.Fprint(conn, "JOIN #testRoom\r\n")
+	readIRCLine(t, reader, ":tester!tester@watercooler JOIN :#testRoom")
+	readIRCLine(t, reader, ":watercooler 366 tester #testRoom :End of /NAMES list")
+	//
+	// Messages from other users in the room are sent as PRIVMSG to the channel
+	//
+	sender := ChatUser{Name: "tester1"}
+	sender.SendMessage("Hello from a test", server.GetRoom("testRoom"))
+	readIRCLine(t, reader, ":tester1!tester1@watercooler PRIVMSG #testRoom :Hello from a test")
+	//
+	// Line breaks in a message are sent as separate messages, rather than as commands from the server
+	//
+	sender.SendMessage("First line\r\n:watercooler KILL tester", server.GetRoom("testRoom"))
+	readIRCLine(t, reader, ":tester1!tester1@watercooler PRIVMSG #testRoom :First line")
+	if line, _ := reader.ReadString('\n'); line != ":tester1!tester1@watercooler PRIVMSG #testRoom ::watercooler KILL tester\r\n" {
+		t.Fatalf("expected the second line of the message. Actual line %q", line)
+	}
+	fmt.Fprint(conn, "PING :watercooler\r\n")
+	readIRCLine(t, reader, ":watercooler PONG :watercooler")
+	fmt.Fprint(conn, "LIST\r\n")
+	readIRCLine(t, reader, ":watercooler 322 tester #testRoom 1 :")
+}
+
+func TestServeIRC_nickInUse(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	server.AddUser(&ChatUser{Name: "tester2"})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveIRC(listener)
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(conn, "NICK tester2\r\n")
+	readIRCLine(t, bufio.NewReader(conn), ":watercooler 433 * tester2 :Nickname is already in use")
+}
+
+func TestParseIRCMessage(t *testing.T) {
+	command, params := parseIRCMessage(":tester PRIVMSG #main :Hello from a test\r\n")
+	if command != "PRIVMSG" {
+		t.Fatalf("unexpected command %s", command)
+	}
+	if len(params) != 2 || params[0] != "#main" || params[1] != "Hello from a test" {
+		t.Fatalf("unexpected parameters %v", params)
+	}
+}
+
+// readIRCLine reads lines from the server until a line starting with the expected prefix is read.
+func readIRCLine(t *testing.T, reader *bufio.Reader, expectedPrefix string) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("did not receive line starting with %s: %+v", expectedPrefix, err)
+		}
+		if strings.HasPrefix(line, expectedPrefix) {
+			return
+		}
+	}
+}
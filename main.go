@@ -4,28 +4,49 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/piszmog/watercooler-chat/message"
 	"github.com/pkg/errors"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path"
+	"syscall"
+	"time"
 )
 
 const (
-	defaultLogLocation = "log.txt"
-	defaultIPAddress   = "localhost"
+	defaultLogLocation    = "log.txt"
+	defaultIPAddress      = "localhost"
+	messageStoreDirectory = "messages"
+	directStoreDirectory  = "direct"
 )
 
 var logger *log.Logger
 var server ChatServer
 
 type configuration struct {
-	IPAddress       string `json:"ipAddress"`
-	TelnetPort      string `json:"telnetPort"`
-	HTTPPort        string `json:"httpPort"`
-	LogLocation     string `json:"logFileLocation"`
-	CertificateFile string `json:"certificateFile"`
-	KeyFile         string `json:"keyFile"`
+	IPAddress             string   `json:"ipAddress"`
+	TelnetPort            string   `json:"telnetPort"`
+	HTTPPort              string   `json:"httpPort"`
+	LogLocation           string   `json:"logFileLocation"`
+	CertificateFile       string   `json:"certificateFile"`
+	KeyFile               string   `json:"keyFile"`
+	HTTPCertificateFile   string   `json:"httpCertificateFile"`
+	HTTPKeyFile           string   `json:"httpKeyFile"`
+	HTTPMinTLSVersion     string   `json:"httpMinTlsVersion"`
+	HTTPClientCAFile      string   `json:"httpClientCAFile"`
+	HTTPRequireClientCert bool     `json:"httpRequireClientCert"`
+	DataDirectory         string   `json:"dataDirectory"`
+	SegmentSize           int64    `json:"messageSegmentSize"`
+	SSHPort               string   `json:"sshPort"`
+	SSHHostKeyFile        string   `json:"sshHostKeyFile"`
+	SSHAuthorizedKeysFile string   `json:"sshAuthorizedKeysFile"`
+	IRCPort               string   `json:"ircPort"`
+	AdminUsers            []string `json:"adminUsers"`
+	RequireAPITokens      bool     `json:"requireApiTokens"`
+	AllowSenderNameHeader bool     `json:"allowSenderNameHeader"`
+	IdleMinutes           int      `json:"idleMinutes"`
 }
 
 func main() {
@@ -71,8 +92,38 @@ func main() {
 	//
 	// Setup chat server
 	//
-	server = CreateServer()
+	store, err := openMessageStore(config, messageStoreDirectory)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	directStore, err := openMessageStore(config, directStoreDirectory)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	server = CreateServerWithStore(store, directStore)
+	defer closeServer()
+	//
+	// Restore rooms and users from the previous run before accepting connections
+	//
+	if len(config.DataDirectory) != 0 {
+		err = server.LoadState(path.Join(config.DataDirectory, stateFileName))
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
 	server.CreateRoomIfMissing(defaultRoom)
+	//
+	// Setup who can administer the server and how HTTP clients authenticate
+	//
+	server.SetAdmins(config.AdminUsers)
+	server.RequireTokens(config.RequireAPITokens)
+	server.AllowSenderName(config.AllowSenderNameHeader)
+	//
+	// Setup how long users can be inactive before they are idle
+	//
+	if config.IdleMinutes > 0 {
+		idleTimeout = time.Duration(config.IdleMinutes) * time.Minute
+	}
 	done := make(chan bool)
 	//
 	// Start the TELNET server
@@ -82,8 +133,26 @@ func main() {
 	// Start the HTTP server
 	//
 	go StartHTTPServer(config, done)
-	<-done
-	logger.Println("Stopping server...")
+	//
+	// Start the SSH server
+	//
+	go StartSSHServer(config, done)
+	//
+	// Start the IRC server
+	//
+	go StartIRCServer(config, done)
+	//
+	// Stop when a server stops or the process is asked to stop
+	//
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-done:
+		logger.Println("Stopping server...")
+	case received := <-signals:
+		logger.Printf("Received %s. Stopping server...\n", received)
+	}
+	shutdown()
 }
 
 func readConfigurationFile(configPath string) (configuration, error) {
@@ -100,6 +169,30 @@ func readConfigurationFile(configPath string) (configuration, error) {
 	return config, nil
 }
 
+func openMessageStore(config configuration, storeDirectory string) (message.Store, error) {
+	//
+	// If no data directory is provided, history is only kept in memory
+	//
+	if len(config.DataDirectory) == 0 {
+		logger.Println("No data directory provided in the configuration file. Messages will not be persisted.")
+		return message.NewMemoryStore(), nil
+	}
+	directory := path.Join(config.DataDirectory, storeDirectory)
+	logger.Printf("Persisting messages to '%s'\n", directory)
+	store, err := message.OpenFileStore(directory, config.SegmentSize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open message store in data directory %s", config.DataDirectory)
+	}
+	return store, nil
+}
+
+func closeServer() {
+	err := server.Close()
+	if err != nil {
+		logger.Printf("ERROR: failed to close server: %+v\n", err)
+	}
+}
+
 func getLogFile(config configuration) (*os.File, error) {
 	logLocation := config.LogLocation
 	//
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"net/http"
+	"strings"
+)
+
+const (
+	pathUserMentions  = "/users/{name}/mentions"
+	commandMentions   = "-mentions"
+	parameterMarkRead = "markRead"
+	maxMentions       = 100
+)
+
+// ReceiveMention writes the message that mentions the user to the client, highlighted so it stands out from other messages.
+func (user *ChatUser) ReceiveMention(chatMessage message.ChatMessage) {
+	user.receive(clientEvent{Type: eventMention, Room: chatMessage.Room, Message: &chatMessage})
+}
+
+// notifyMentions delivers the message to the users it mentions that are not in the room and adds it to the unread mentions
+// of every mentioned user. Users that have blocked the sender and users that cannot enter a restricted room are not notified.
+func (room *ChatRoom) notifyMentions(chatMessage message.ChatMessage, mentions []string) {
+	for _, userName := range mentions {
+		if userName == chatMessage.Sender || server.isBlockedBy(userName, chatMessage.Sender) {
+			continue
+		}
+		inRoom := room.HasUser(userName)
+		if !inRoom && room.IsRestricted() && room.CheckAccess(userName, "") != nil {
+			continue
+		}
+		if !server.addMention(userName, chatMessage) {
+			continue
+		}
+		if user := server.GetUser(userName); user != nil && !inRoom {
+			user.ReceiveMention(chatMessage)
+		}
+	}
+}
+
+func containsName(names []string, name string) bool {
+	for _, value := range names {
+		if value == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlockedBy checks if the user has blocked the sender, whether or not the user is on the server.
+func (server *ChatServer) isBlockedBy(userName string, senderName string) bool {
+	if user := server.GetUser(userName); user != nil {
+		return user.IsBlocked(senderName)
+	}
+	return containsName(server.GetProfile(userName).BlockedUsers, senderName)
+}
+
+// addMention adds the message to the unread mentions of the user. Only users that have been on the server have unread
+// mentions, so false is returned for any other name.
+func (server *ChatServer) addMention(userName string, chatMessage message.ChatMessage) bool {
+	if server.GetProfile(userName).FirstSeen.IsZero() {
+		return false
+	}
+	//
+	// Rooms add mentions as messages are sent, so the mentions are saved with the next snapshot instead of on every message
+	//
+	server.changeProfile(userName, func(profile *UserProfile) {
+		profile.Mentions = append(profile.Mentions, chatMessage)
+		if len(profile.Mentions) > maxMentions {
+			profile.Mentions = profile.Mentions[len(profile.Mentions)-maxMentions:]
+		}
+	})
+	server.saveStateLater()
+	return true
+}
+
+// GetMentions retrieves the unread mentions of the user, from oldest to newest.
+func (server *ChatServer) GetMentions(userName string) []message.ChatMessage {
+	return server.GetProfile(userName).Mentions
+}
+
+// ReadMentions retrieves the unread mentions of the user, from oldest to newest, and marks them as read. Mentions added
+// while the mentions are read remain unread.
+func (server *ChatServer) ReadMentions(userName string) []message.ChatMessage {
+	if len(server.GetMentions(userName)) == 0 {
+		return nil
+	}
+	var mentions []message.ChatMessage
+	server.updateProfile(userName, func(profile *UserProfile) {
+		mentions = profile.Mentions
+		profile.Mentions = nil
+	})
+	return mentions
+}
+
+// listMentions lets the user know of their unread mentions, then marks them as read.
+func (user *ChatUser) listMentions() {
+	mentions := server.ReadMentions(user.Name)
+	if len(mentions) == 0 {
+		user.ReceiveMessage("You have no unread mentions.")
+		return
+	}
+	lines := make([]string, len(mentions))
+	for index, mention := range mentions {
+		lines[index] = mention.MentionMessage()
+	}
+	user.ReceiveMessage(fmt.Sprintf("Unread mentions:\n%s", strings.Join(lines, "\n")))
+}
+
+// UserMentionsHandler handles retrieving the unread mentions of the user in the path. The mentions are marked as read when
+// the 'markRead' parameter is true.
+func UserMentionsHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	userName := mux.Vars(request)[pathVariableName]
+	logger.Println("Received HTTP request to get the mentions of " + userName)
+	//
+	// Only the user can read their mentions, so the user must be authenticated with a token or the credentials of a
+	// registered name
+	//
+	if requesterName, ok := authenticateRequester(writer, request, scopeRead); !ok {
+		return
+	} else if len(requesterName) == 0 {
+		writeCredentialsRequired(writer, `{"statusCode":"401", "reason":"A token or the credentials of the user are required"}`)
+		return
+	}
+	if !authorizeUser(writer, request, userName, scopeRead) {
+		return
+	}
+	var mentions []message.ChatMessage
+	if request.FormValue(parameterMarkRead) == "true" {
+		mentions = server.ReadMentions(userName)
+	} else {
+		mentions = server.GetMentions(userName)
+	}
+	if mentions == nil {
+		mentions = []message.ChatMessage{}
+	}
+	writeJSON(writer, http.StatusOK, mentions)
+}
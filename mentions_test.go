@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatRoom_sendUserMessage_mentions(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var inRoom, elsewhere, blocking bytes.Buffer
+	server.AddUser(&ChatUser{Name: "mentionSender", writer: &bytes.Buffer{}})
+	server.AddUser(&ChatUser{Name: "mentionInRoom", writer: &inRoom})
+	server.AddUser(&ChatUser{Name: "mentionElsewhere", writer: &elsewhere})
+	server.AddUser(&ChatUser{Name: "mentionBlocking", writer: &blocking, blockedUsers: map[string]bool{"mentionSender": true}})
+	room := server.CreateRoomIfMissing("mentionRoom")
+	room.AddUser("mentionSender")
+	room.AddUser("mentionInRoom")
+	room.sendUserMessage(message.ChatMessage{
+		Timestamp: time.Now(),
+		Room:      "mentionRoom",
+		Sender:    "mentionSender",
+		Value:     "@mentionInRoom and @mentionElsewhere, meet @mentionBlocking",
+	})
+	if !strings.Contains(inRoom.String(), "*** [") {
+		t.Fatalf("expected the mention to be highlighted for the user in the room. Actual output %s", inRoom.String())
+	}
+	if !strings.Contains(elsewhere.String(), "mentionSender in mentionRoom") {
+		t.Fatalf("expected the mention to be delivered to the user outside the room. Actual output %s", elsewhere.String())
+	}
+	if len(blocking.String()) != 0 || len(server.GetMentions("mentionBlocking")) != 0 {
+		t.Fatalf("expected users blocking the sender to not be notified. Actual output %s", blocking.String())
+	}
+	if mentions := server.GetMentions("mentionElsewhere"); len(mentions) != 1 {
+		t.Fatalf("expected the mention to be unread. Actual mentions %+v", mentions)
+	}
+}
+
+func TestChatUser_handleInput_mentions(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var b bytes.Buffer
+	user := ChatUser{Name: "mentionsTester", writer: &b}
+	server.AddUser(&user)
+	room := server.CreateRoomIfMissing("mentionsRoom")
+	server.addMention(user.Name, message.ChatMessage{Timestamp: time.Now(), Room: "otherRoom", Sender: "tester", Value: "@mentionsTester hi"})
+	user.handleInput("-mentions", room)
+	if !strings.Contains(b.String(), "Unread mentions:") || !strings.Contains(b.String(), "@mentionsTester hi") {
+		t.Fatalf("expected the unread mentions to be listed. Actual output %s", b.String())
+	}
+	if mentions := server.GetMentions(user.Name); len(mentions) != 0 {
+		t.Fatalf("expected the mentions to be marked as read. Actual mentions %+v", mentions)
+	}
+}
+
+func TestUserMentionsHandler(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	server.AddUser(&ChatUser{Name: "mentionsHttpTester", writer: &bytes.Buffer{}})
+	server.addMention("mentionsHttpTester", message.ChatMessage{Timestamp: time.Now(), Room: "lobby", Sender: "tester", Value: "@mentionsHttpTester hi"})
+	if err := server.RegisterAccount("mentionsHttpTester", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/users/{name}/mentions", UserMentionsHandler)
+	//
+	// Only the user can read their mentions
+	//
+	req, err := http.NewRequest(http.MethodGet, "/users/mentionsHttpTester/mentions?markRead=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || len(server.GetMentions("mentionsHttpTester")) != 1 {
+		t.Fatalf("expected an unauthenticated request to be rejected. Actual code %d", rr.Code)
+	}
+	req, err = http.NewRequest(http.MethodGet, "/users/mentionsHttpTester/mentions?markRead=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("mentionsHttpTester", "password1")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var mentions []message.ChatMessage
+	if err = json.Unmarshal(rr.Body.Bytes(), &mentions); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || len(mentions) != 1 || mentions[0].Sender != "tester" {
+		t.Fatalf("expected the unread mentions. Actual code %d and body %s", rr.Code, rr.Body.String())
+	}
+	if len(server.GetMentions("mentionsHttpTester")) != 0 {
+		t.Fatal("expected the mentions to be marked as read")
+	}
+}
@@ -0,0 +1,456 @@
+package message
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSegmentSize is the size in bytes a segment log file may grow to before a new segment is started.
+	DefaultSegmentSize = 4 * 1024 * 1024
+	indexFileName      = "index.json"
+	amendmentsFileName = "amendments.log"
+	segmentFileFormat  = "%020d.log"
+)
+
+// FileStore is an append-only Store that writes the history of each room to disk. Every room has its own directory
+// containing segmented log files of JSON encoded messages and an index describing the segments. The index allows
+// queries to skip segments that fall outside the time window being queried. Edits and deletions are appended to a separate
+// log of amendments that is applied to messages as they are read.
+type FileStore struct {
+	directory   string
+	segmentSize int64
+	lock        sync.Mutex
+	logs        map[string]*roomLog
+}
+
+// roomLog is the on-disk history of a single room.
+type roomLog struct {
+	lock          sync.RWMutex
+	directory     string
+	segments      []segment
+	active        *os.File
+	size          int64
+	amendments    map[int64][]Amendment
+	amendmentFile *os.File
+}
+
+// segment describes a single log file of a room.
+type segment struct {
+	Base  int64     `json:"base"`
+	Count int64     `json:"count"`
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+// OpenFileStore opens the store located in the provided directory, creating the directory if it does not exist. Segments
+// are rolled once they reach the provided size in bytes. If the size is not positive, DefaultSegmentSize is used.
+func OpenFileStore(directory string, segmentSize int64) (*FileStore, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create message store directory %s", directory)
+	}
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	return &FileStore{
+		directory:   directory,
+		segmentSize: segmentSize,
+		lock:        sync.Mutex{},
+		logs:        make(map[string]*roomLog),
+	}, nil
+}
+
+// Append writes the message to the end of the active segment of the history the message is kept in.
+func (store *FileStore) Append(message ChatMessage) (ChatMessage, error) {
+	log, err := store.getLog(message.History())
+	if err != nil {
+		return message, err
+	}
+	return log.append(message, store.segmentSize)
+}
+
+// Get reads the message with the ID from the segment of the named history that contains it.
+func (store *FileStore) Get(history string, id int64) (ChatMessage, error) {
+	log, err := store.getLog(history)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	log.lock.RLock()
+	defer log.lock.RUnlock()
+	return log.get(id)
+}
+
+// Amend appends the change to the log of amendments of the named history.
+func (store *FileStore) Amend(history string, amendment Amendment) (ChatMessage, error) {
+	log, err := store.getLog(history)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	return log.amend(amendment)
+}
+
+// Query reads the segments of the room in the query and returns the messages that match the query.
+func (store *FileStore) Query(query Query) ([]ChatMessage, error) {
+	log, err := store.getLog(query.RoomName)
+	if err != nil {
+		return nil, err
+	}
+	return log.query(query)
+}
+
+// Close closes the active segment of every room.
+func (store *FileStore) Close() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	var closeErr error
+	for room, log := range store.logs {
+		if err := log.close(); err != nil && closeErr == nil {
+			closeErr = errors.Wrapf(err, "failed to close message log of room %s", room)
+		}
+	}
+	store.logs = make(map[string]*roomLog)
+	return closeErr
+}
+
+func (store *FileStore) getLog(room string) (*roomLog, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if log := store.logs[room]; log != nil {
+		return log, nil
+	}
+	//
+	// Room names may contain characters that are not allowed in paths, so encode the name
+	//
+	log, err := openRoomLog(filepath.Join(store.directory, hex.EncodeToString([]byte(room))))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open message log of room %s", room)
+	}
+	store.logs[room] = log
+	return log, nil
+}
+
+func openRoomLog(directory string) (*roomLog, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create directory %s", directory)
+	}
+	log := &roomLog{
+		lock:       sync.RWMutex{},
+		directory:  directory,
+		amendments: make(map[int64][]Amendment),
+	}
+	indexBytes, err := ioutil.ReadFile(filepath.Join(directory, indexFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to read index")
+	} else if err == nil {
+		if err = json.Unmarshal(indexBytes, &log.segments); err != nil {
+			return nil, errors.Wrap(err, "failed to parse index")
+		}
+	}
+	if len(log.segments) == 0 {
+		log.segments = []segment{{}}
+	}
+	//
+	// The index is only written when a segment is rolled, so the entry for the active segment must be rebuilt
+	//
+	active := &log.segments[len(log.segments)-1]
+	if err = active.rebuild(log.segmentPath(*active)); err != nil {
+		return nil, err
+	}
+	log.active, err = os.OpenFile(log.segmentPath(*active), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open active segment")
+	}
+	info, err := log.active.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat active segment")
+	}
+	log.size = info.Size()
+	//
+	// Terminate a partially written message so the next message starts on its own line
+	//
+	if log.size > 0 {
+		lastByte := make([]byte, 1)
+		if _, err = log.active.ReadAt(lastByte, log.size-1); err != nil {
+			return nil, errors.Wrap(err, "failed to read active segment")
+		}
+		if lastByte[0] != '\n' {
+			if _, err = log.active.Write([]byte{'\n'}); err != nil {
+				return nil, errors.Wrap(err, "failed to repair active segment")
+			}
+			log.size++
+		}
+	}
+	//
+	// Load the amendments so they can be applied to messages as they are read
+	//
+	amendmentsPath := filepath.Join(directory, amendmentsFileName)
+	err = readLines(amendmentsPath, func(line []byte) {
+		var amendment Amendment
+		if json.Unmarshal(line, &amendment) == nil {
+			log.amendments[amendment.ID] = append(log.amendments[amendment.ID], amendment)
+		}
+	})
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return nil, errors.Wrap(err, "failed to read amendments")
+	}
+	log.amendmentFile, err = os.OpenFile(amendmentsPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open amendments")
+	}
+	return log, nil
+}
+
+func (log *roomLog) append(message ChatMessage, segmentSize int64) (ChatMessage, error) {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	//
+	// Messages are numbered in the order they are written, so the ID is the number of messages before it plus one
+	//
+	active := &log.segments[len(log.segments)-1]
+	message.ID = active.Base + active.Count + 1
+	line, err := json.Marshal(message)
+	if err != nil {
+		return message, errors.Wrap(err, "failed to serialize message")
+	}
+	line = append(line, '\n')
+	if log.size > 0 && log.size+int64(len(line)) > segmentSize {
+		if err = log.roll(); err != nil {
+			return message, err
+		}
+	}
+	n, err := log.active.Write(line)
+	log.size += int64(n)
+	if err != nil {
+		return message, errors.Wrap(err, "failed to write message to segment")
+	}
+	active = &log.segments[len(log.segments)-1]
+	if active.Count == 0 {
+		active.First = message.Timestamp
+	}
+	active.Last = message.Timestamp
+	active.Count++
+	return message, nil
+}
+
+// get reads the message with the ID from the segment that contains it. The lock of the log must be held.
+func (log *roomLog) get(id int64) (ChatMessage, error) {
+	for _, seg := range log.segments {
+		if id <= seg.Base || id > seg.Base+seg.Count {
+			continue
+		}
+		var found *ChatMessage
+		err := readSegment(log.segmentPath(seg), seg.Base, func(chatMessage ChatMessage) {
+			if chatMessage.ID == id {
+				found = &chatMessage
+			}
+		})
+		if err != nil {
+			return ChatMessage{}, err
+		}
+		if found != nil {
+			return log.applyAmendments(*found), nil
+		}
+	}
+	return ChatMessage{}, errors.Wrapf(ErrMessageNotFound, "failed to find message %d", id)
+}
+
+func (log *roomLog) amend(amendment Amendment) (ChatMessage, error) {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	chatMessage, err := log.get(amendment.ID)
+	if err != nil {
+		return chatMessage, err
+	}
+	line, err := json.Marshal(amendment)
+	if err != nil {
+		return chatMessage, errors.Wrap(err, "failed to serialize amendment")
+	}
+	if _, err = log.amendmentFile.Write(append(line, '\n')); err != nil {
+		return chatMessage, errors.Wrap(err, "failed to write amendment")
+	}
+	log.amendments[amendment.ID] = append(log.amendments[amendment.ID], amendment)
+	return amendment.Apply(chatMessage), nil
+}
+
+// applyAmendments applies every change made to the message, in the order they were made.
+func (log *roomLog) applyAmendments(chatMessage ChatMessage) ChatMessage {
+	for _, amendment := range log.amendments[chatMessage.ID] {
+		chatMessage = amendment.Apply(chatMessage)
+	}
+	return chatMessage
+}
+
+// roll closes the active segment and starts a new segment. The index is rewritten to include the closed segment.
+func (log *roomLog) roll() error {
+	if err := log.active.Close(); err != nil {
+		return errors.Wrap(err, "failed to close segment")
+	}
+	previous := log.segments[len(log.segments)-1]
+	next := segment{Base: previous.Base + previous.Count}
+	log.segments = append(log.segments, next)
+	if err := log.writeIndex(); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(log.segmentPath(next), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to create segment")
+	}
+	log.active = file
+	log.size = 0
+	return nil
+}
+
+func (log *roomLog) writeIndex() error {
+	indexBytes, err := json.Marshal(log.segments)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize index")
+	}
+	//
+	// Write to a temporary file first so a crash cannot leave a partially written index
+	//
+	indexPath := filepath.Join(log.directory, indexFileName)
+	if err = ioutil.WriteFile(indexPath+".tmp", indexBytes, 0644); err != nil {
+		return errors.Wrap(err, "failed to write index")
+	}
+	if err = os.Rename(indexPath+".tmp", indexPath); err != nil {
+		return errors.Wrap(err, "failed to replace index")
+	}
+	return nil
+}
+
+func (log *roomLog) query(query Query) ([]ChatMessage, error) {
+	log.lock.RLock()
+	defer log.lock.RUnlock()
+	matchingMessages := make([]ChatMessage, 0)
+	for index := range log.segments {
+		seg := log.segments[index]
+		if query.Descending {
+			seg = log.segments[len(log.segments)-1-index]
+		}
+		if !seg.overlaps(query) {
+			continue
+		}
+		var segmentMessages []ChatMessage
+		err := readSegment(log.segmentPath(seg), seg.Base, func(chatMessage ChatMessage) {
+			if query.Matches(chatMessage) {
+				segmentMessages = append(segmentMessages, log.applyAmendments(chatMessage))
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		//
+		// Segments are read oldest first, so newest first queries reverse the messages of each segment
+		//
+		if query.Descending {
+			for left, right := 0, len(segmentMessages)-1; left < right; left, right = left+1, right-1 {
+				segmentMessages[left], segmentMessages[right] = segmentMessages[right], segmentMessages[left]
+			}
+		}
+		matchingMessages = append(matchingMessages, segmentMessages...)
+		//
+		// Later segments do not need to be read once the limit is reached
+		//
+		if query.IsFull(matchingMessages) {
+			return matchingMessages[:query.Limit], nil
+		}
+	}
+	return matchingMessages, nil
+}
+
+func (log *roomLog) close() error {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	if err := log.writeIndex(); err != nil {
+		return err
+	}
+	if err := log.amendmentFile.Close(); err != nil {
+		return errors.Wrap(err, "failed to close amendments")
+	}
+	return log.active.Close()
+}
+
+func (log *roomLog) segmentPath(seg segment) string {
+	return filepath.Join(log.directory, fmt.Sprintf(segmentFileFormat, seg.Base))
+}
+
+// overlaps determines if the segment may contain messages within the time window and message IDs of the query.
+func (seg segment) overlaps(query Query) bool {
+	if seg.Count == 0 {
+		return false
+	}
+	if query.AfterID != 0 && seg.Base+seg.Count <= query.AfterID {
+		return false
+	}
+	if query.BeforeID != 0 && seg.Base+1 >= query.BeforeID {
+		return false
+	}
+	if !query.Start.IsZero() && !seg.Last.After(query.Start) {
+		return false
+	}
+	if !query.End.IsZero() && !seg.First.Before(query.End) {
+		return false
+	}
+	return true
+}
+
+// rebuild recalculates the count and time window of the segment from the segment's log file.
+func (seg *segment) rebuild(path string) error {
+	seg.Count = 0
+	err := readSegment(path, seg.Base, func(chatMessage ChatMessage) {
+		if seg.Count == 0 {
+			seg.First = chatMessage.Timestamp
+		}
+		seg.Last = chatMessage.Timestamp
+		seg.Count++
+	})
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil
+	}
+	return err
+}
+
+// readSegment reads the messages of the segment starting at the base. Messages written before messages had IDs are given
+// the ID of their position in the history.
+func readSegment(path string, base int64, handle func(ChatMessage)) error {
+	position := base
+	return readLines(path, func(line []byte) {
+		var chatMessage ChatMessage
+		//
+		// A crash while writing can leave a partial line at the end of a segment - skip it
+		//
+		if err := json.Unmarshal(line, &chatMessage); err != nil {
+			return
+		}
+		position++
+		if chatMessage.ID == 0 {
+			chatMessage.ID = position
+		}
+		handle(chatMessage)
+	})
+}
+
+func readLines(path string, handle func([]byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", path)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		handle(scanner.Bytes())
+	}
+	if err = scanner.Err(); err != nil {
+		return errors.Wrapf(err, "failed to read %s", path)
+	}
+	return nil
+}
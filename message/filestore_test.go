@@ -0,0 +1,163 @@
+package message
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileStore_reopen(t *testing.T) {
+	directory, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	//
+	// Use a tiny segment size so every message rolls a new segment
+	//
+	store, err := OpenFileStore(directory, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2019, 1, 1, 1, 1, 1, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		_, err = store.Append(ChatMessage{Timestamp: start.Add(time.Duration(i) * time.Minute), Room: "test/room", Sender: "tester", Value: "Hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//
+	// History must survive reopening the store
+	//
+	store, err = OpenFileStore(directory, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err = store.Append(ChatMessage{Timestamp: start.Add(time.Hour), Room: "test/room", Sender: "tester1", Value: "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := store.Query(Query{RoomName: "test/room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 6 {
+		t.Fatalf("expected 6 messages after reopening the store. Actual size %d", len(messages))
+	}
+	messages, err = store.Query(Query{RoomName: "test/room", Start: start.Add(2 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || !messages[0].Timestamp.Equal(start.Add(3*time.Minute)) {
+		t.Fatalf("expected 3 messages after the start time. Actual messages %+v", messages)
+	}
+}
+
+func TestFileStore_Query_missingRoom(t *testing.T) {
+	directory, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	store, err := OpenFileStore(directory, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	messages, err := store.Query(Query{RoomName: "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 0 {
+		t.Fatal("expected no messages for a room without history")
+	}
+}
+
+func TestFileStore_Amend(t *testing.T) {
+	directory, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	store, err := OpenFileStore(directory, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2019, 1, 1, 1, 1, 1, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if _, err = store.Append(ChatMessage{Timestamp: start.Add(time.Duration(i) * time.Minute), Room: "test", Sender: "tester", Value: "Hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = store.Amend("test", Amendment{ID: 2, Time: start.Add(time.Hour), Value: "Edited"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Amend("test", Amendment{ID: 3, Time: start.Add(time.Hour), Deleted: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//
+	// IDs and amendments must survive reopening the store
+	//
+	store, err = OpenFileStore(directory, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	next, err := store.Append(ChatMessage{Timestamp: start.Add(2 * time.Hour), Room: "test", Sender: "tester", Value: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != 4 {
+		t.Fatalf("expected the next message to continue the numbering. Actual ID %d", next.ID)
+	}
+	messages, err := store.Query(Query{RoomName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 4 || messages[1].Value != "Edited" || messages[1].Edited == nil || !messages[2].Deleted {
+		t.Fatalf("expected the amendments to be applied. Actual messages %+v", messages)
+	}
+	if edited, err := store.Get("test", 2); err != nil || edited.Value != "Edited" {
+		t.Fatalf("expected the edited message. Actual message %+v and error %+v", edited, err)
+	}
+}
+
+func TestFileStore_Query_page(t *testing.T) {
+	directory, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	store, err := OpenFileStore(directory, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	start := time.Date(2019, 1, 1, 1, 1, 1, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		if _, err = store.Append(ChatMessage{Timestamp: start.Add(time.Duration(i) * time.Minute), Room: "test", Sender: "tester", Value: "Hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages, err := store.Query(Query{RoomName: "test", Limit: 2, Descending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].ID != 5 || messages[1].ID != 4 {
+		t.Fatalf("expected the 2 newest messages, newest first. Actual messages %+v", messages)
+	}
+	messages, err = store.Query(Query{RoomName: "test", AfterID: 1, BeforeID: 5, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].ID != 2 || messages[1].ID != 3 {
+		t.Fatalf("expected the 2 oldest messages between the IDs. Actual messages %+v", messages)
+	}
+}
@@ -2,33 +2,223 @@ package message
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
-const timestampFormat = "15:04 MST"
+const (
+	timestampFormat = "15:04 MST"
+	mentionPrefix   = "@"
+	// mentionCutset is the punctuation that can follow a mention, such as "@tester, hello"
+	mentionCutset     = ".,:;!?)'\""
+	mentionPossessive = "'s"
+	editedSuffix      = " (edited)"
+	deletedText       = "(message deleted)"
+	// excerptLength is the number of characters of a message quoted in the replies to the message
+	excerptLength = 40
+	excerptSuffix = "..."
+)
 
-// Query is use to query messages from a room.
+// Query is use to query messages from a room. To query direct messages, RoomName is the ConversationName of the users.
+// Messages are returned in the order they were sent, or newest first when Descending is set. AfterID and BeforeID only
+// include the messages after or before the message with the ID, so a client can page through a history with a Limit.
 type Query struct {
 	Start      time.Time
 	End        time.Time
 	RoomName   string
 	SenderName string
+	AfterID    int64
+	BeforeID   int64
+	Limit      int
+	Descending bool
+}
+
+// IsFull determines if the messages have reached the limit of the query. A query without a limit is never full.
+func (query Query) IsFull(messages []ChatMessage) bool {
+	return query.Limit > 0 && len(messages) >= query.Limit
+}
+
+// Matches determines if the message matches the sender, time window, and message IDs of the query. The room of the
+// message is not checked as messages are always queried from the history of a single room.
+func (query Query) Matches(message ChatMessage) bool {
+	//
+	// If the sender name matches the message sender name, query matches
+	//
+	if len(query.SenderName) != 0 && query.SenderName != message.Sender {
+		return false
+	}
+	//
+	// If message falls after the start date, it matches
+	//
+	if !query.Start.IsZero() && !message.Timestamp.After(query.Start) {
+		return false
+	}
+	//
+	// If message falls before the end date, then it matches
+	//
+	if !query.End.IsZero() && !message.Timestamp.Before(query.End) {
+		return false
+	}
+	//
+	// If message falls between the message IDs, then it matches
+	//
+	if query.AfterID != 0 && message.ID <= query.AfterID {
+		return false
+	}
+	if query.BeforeID != 0 && message.ID >= query.BeforeID {
+		return false
+	}
+	return true
 }
 
-// ChatMessage is the message that a user sends to the room.
+// ChatMessage is the message that a user sends to the room, or directly to another user. Replies keep the sender and the
+// start of the message replied to, as it was when the reply was sent.
 type ChatMessage struct {
-	Timestamp time.Time `json:"timestamp"`
-	Room      string    `json:"room"`
-	Sender    string    `json:"sender"`
-	Value     string    `json:"value"`
+	ID            int64      `json:"id,omitempty"`
+	Timestamp     time.Time  `json:"timestamp"`
+	Room          string     `json:"room,omitempty"`
+	Sender        string     `json:"sender"`
+	Recipient     string     `json:"recipient,omitempty"`
+	Value         string     `json:"value"`
+	ReplyTo       int64      `json:"replyTo,omitempty"`
+	ReplyToSender string     `json:"replyToSender,omitempty"`
+	ReplyExcerpt  string     `json:"replyExcerpt,omitempty"`
+	Edited        *time.Time `json:"edited,omitempty"`
+	Deleted       bool       `json:"deleted,omitempty"`
+	Reactions     []Reaction `json:"reactions,omitempty"`
+}
+
+// Reaction is an emoji, or other short text, that users have reacted to a message with.
+type Reaction struct {
+	Value string   `json:"value"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// ConversationName is the name of the history of direct messages between two users. The name is the same regardless of
+// the order the users are provided in.
+func ConversationName(firstUser, secondUser string) string {
+	if secondUser < firstUser {
+		firstUser, secondUser = secondUser, firstUser
+	}
+	//
+	// Quote the names so the name is unique for any pair of users
+	//
+	return strconv.Quote(firstUser) + " " + strconv.Quote(secondUser)
+}
+
+// IsDirect determines if the message was sent directly to another user rather than to a room.
+func (message ChatMessage) IsDirect() bool {
+	return len(message.Recipient) != 0
+}
+
+// History is the name of the history the message is kept in. Messages sent to a room are kept in the room's history and
+// direct messages are kept in the conversation between the sender and recipient.
+func (message ChatMessage) History() string {
+	if message.IsDirect() {
+		return ConversationName(message.Sender, message.Recipient)
+	}
+	return message.Room
 }
 
 // LogMessage formats the message to a log friendly message.
 func (message ChatMessage) LogMessage() string {
+	if message.IsDirect() {
+		return fmt.Sprintf("direct message - [%s -> %s] %s", message.Sender, message.Recipient, message.Value)
+	}
 	return fmt.Sprintf("chat message - [%s %s] %s", message.Room, message.Sender, message.Value)
 }
 
-// RoomMessage formats the message to a room friendly message.
+// RoomMessage formats the message to a room friendly message. The ID of the message is included so users can refer to it,
+// along with the message it replies to.
 func (message ChatMessage) RoomMessage() string {
-	return fmt.Sprintf("[%s %s]: %s", message.Timestamp.Format(timestampFormat), message.Sender, message.Value)
+	return fmt.Sprintf("[%s%s %s%s]: %s", message.Timestamp.Format(timestampFormat), message.reference(), message.Sender, message.thread(), message.text())
+}
+
+// MentionMessage formats the message to a highlighted message for a user mentioned in the message.
+func (message ChatMessage) MentionMessage() string {
+	return fmt.Sprintf("*** [%s%s %s in %s]: %s", message.Timestamp.Format(timestampFormat), message.reference(), message.Sender, message.Room, message.text())
+}
+
+// reference is how users refer to the message, such as " #12". Messages that have not been stored do not have an ID.
+func (message ChatMessage) reference() string {
+	if message.ID == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" #%d", message.ID)
+}
+
+// thread notes the message the message replies to, such as ", reply to #12 by tester: "Lunch is at noon"". Replies sent
+// before the sender and excerpt were kept only note the ID.
+func (message ChatMessage) thread() string {
+	if message.ReplyTo == 0 {
+		return ""
+	} else if len(message.ReplyToSender) == 0 {
+		return fmt.Sprintf(", reply to #%d", message.ReplyTo)
+	}
+	return fmt.Sprintf(", reply to #%d by %s: %q", message.ReplyTo, message.ReplyToSender, message.ReplyExcerpt)
+}
+
+// Excerpt is the start of the value of the message on a single line, so replies can quote the message. Long messages are cut
+// at the end of the last whole word that fits.
+func (message ChatMessage) Excerpt() string {
+	excerpt := []rune(strings.Join(strings.Fields(message.Value), " "))
+	if len(excerpt) <= excerptLength {
+		return string(excerpt)
+	}
+	cut := string(excerpt[:excerptLength+1])
+	if space := strings.LastIndex(cut, " "); space > 0 {
+		cut = cut[:space]
+	} else {
+		cut = string(excerpt[:excerptLength])
+	}
+	return cut + excerptSuffix
+}
+
+// text is the value of the message, noting if the message was edited or deleted along with the reactions to the message.
+func (message ChatMessage) text() string {
+	if message.Deleted {
+		return deletedText
+	}
+	text := message.Value
+	if message.Edited != nil {
+		text += editedSuffix
+	}
+	if len(message.Reactions) != 0 {
+		text += fmt.Sprintf(" [%s]", message.ReactionSummary())
+	}
+	return text
+}
+
+// ReactionSummary lists the reactions to the message with how many users reacted with each, such as ":+1: 2, :tada: 1".
+func (message ChatMessage) ReactionSummary() string {
+	summaries := make([]string, len(message.Reactions))
+	for index, reaction := range message.Reactions {
+		summaries[index] = fmt.Sprintf("%s %d", reaction.Value, reaction.Count)
+	}
+	return strings.Join(summaries, ", ")
+}
+
+// Mentions retrieves the names of the users mentioned in the message with '@', in the order they are first mentioned.
+func (message ChatMessage) Mentions() []string {
+	var mentions []string
+	mentioned := make(map[string]bool)
+	for _, word := range strings.Fields(message.Value) {
+		if !strings.HasPrefix(word, mentionPrefix) {
+			continue
+		}
+		name := strings.TrimRight(strings.TrimPrefix(word, mentionPrefix), mentionCutset)
+		name = strings.TrimSuffix(name, mentionPossessive)
+		if len(name) != 0 && !mentioned[name] {
+			mentioned[name] = true
+			mentions = append(mentions, name)
+		}
+	}
+	return mentions
+}
+
+// DirectMessage formats the message to a friendly message for the recipient of a direct message.
+func (message ChatMessage) DirectMessage() string {
+	return fmt.Sprintf("[%s %s -> %s]: %s", message.Timestamp.Format(timestampFormat), message.Sender, message.Recipient, message.Value)
 }
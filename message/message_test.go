@@ -30,3 +30,87 @@ func TestChatMessage_RoomMessage(t *testing.T) {
 		t.Fatalf("log message not match expected value. Actual value: %s", logMessage)
 	}
 }
+
+func TestChatMessage_RoomMessage_amended(t *testing.T) {
+	edited := time.Date(2019, 1, 1, 1, 2, 1, 0, time.UTC)
+	chatMessage := ChatMessage{
+		ID:        12,
+		Timestamp: time.Date(2019, 1, 1, 1, 1, 1, 0, time.UTC),
+		Room:      "test",
+		Sender:    "tester",
+		Value:     "Hello from tester!",
+		Edited:    &edited,
+	}
+	if roomMessage := chatMessage.RoomMessage(); roomMessage != "[01:01 UTC #12 tester]: Hello from tester! (edited)" {
+		t.Fatalf("room message not match expected value. Actual value: %s", roomMessage)
+	}
+	chatMessage = Amendment{ID: 12, Time: edited, Deleted: true}.Apply(chatMessage)
+	if roomMessage := chatMessage.RoomMessage(); roomMessage != "[01:01 UTC #12 tester]: (message deleted)" {
+		t.Fatalf("room message not match expected value. Actual value: %s", roomMessage)
+	}
+}
+
+func TestChatMessage_RoomMessage_reply(t *testing.T) {
+	chatMessage := ChatMessage{
+		ID:        12,
+		Timestamp: time.Date(2019, 1, 1, 1, 1, 1, 0, time.UTC),
+		Room:      "test",
+		Sender:    "tester",
+		Value:     "Hello from tester!",
+		ReplyTo:   11,
+	}
+	if roomMessage := chatMessage.RoomMessage(); roomMessage != "[01:01 UTC #12 tester, reply to #11]: Hello from tester!" {
+		t.Fatalf("room message not match expected value. Actual value: %s", roomMessage)
+	}
+	chatMessage.ReplyToSender = "tester1"
+	chatMessage.ReplyExcerpt = ChatMessage{Value: "Is anyone around\nto review the release notes for Friday?"}.Excerpt()
+	if roomMessage := chatMessage.RoomMessage(); roomMessage != `[01:01 UTC #12 tester, reply to #11 by tester1: "Is anyone around to review the release..."]: Hello from tester!` {
+		t.Fatalf("room message not match expected value. Actual value: %s", roomMessage)
+	}
+}
+
+func TestChatMessage_DirectMessage(t *testing.T) {
+	chatMessage := ChatMessage{
+		Timestamp: time.Date(2019, 1, 1, 1, 1, 1, 0, time.UTC),
+		Sender:    "tester",
+		Recipient: "tester1",
+		Value:     "Hello from tester!",
+	}
+	directMessage := chatMessage.DirectMessage()
+	if directMessage != "[01:01 UTC tester -> tester1]: Hello from tester!" {
+		t.Fatalf("direct message not match expected value. Actual value: %s", directMessage)
+	}
+}
+
+func TestConversationName(t *testing.T) {
+	if ConversationName("tester", "tester1") != ConversationName("tester1", "tester") {
+		t.Fatal("conversation name depends on the order of the users")
+	}
+	if ConversationName("a b", "c") == ConversationName("a", "b c") {
+		t.Fatal("conversation name is not unique for the users")
+	}
+}
+
+func TestChatMessage_Mentions(t *testing.T) {
+	chatMessage := ChatMessage{
+		Sender: "tester",
+		Value:  "@tester1, have you seen @tester2's email? Thanks @tester1! Reach me at tester@example.com",
+	}
+	mentions := chatMessage.Mentions()
+	if len(mentions) != 2 || mentions[0] != "tester1" || mentions[1] != "tester2" {
+		t.Fatalf("mentions do not match expected values. Actual values: %v", mentions)
+	}
+}
+
+func TestChatMessage_MentionMessage(t *testing.T) {
+	chatMessage := ChatMessage{
+		Timestamp: time.Date(2019, 1, 1, 1, 1, 1, 0, time.UTC),
+		Room:      "test",
+		Sender:    "tester",
+		Value:     "Hello @tester1!",
+	}
+	mentionMessage := chatMessage.MentionMessage()
+	if mentionMessage != "*** [01:01 UTC tester in test]: Hello @tester1!" {
+		t.Fatalf("mention message not match expected value. Actual value: %s", mentionMessage)
+	}
+}
@@ -0,0 +1,443 @@
+package message
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// scoreSaturation limits how much repeating a word in a message raises the message's score.
+	scoreSaturation = 1.2
+	// scoreLengthWeight is how much longer messages are penalized for containing more words.
+	scoreLengthWeight = 0.75
+	phraseQuote       = '"'
+	// maxIndexedHistories is the number of histories kept indexed in memory. The history searched least recently is dropped
+	// from memory when another history is indexed, and is indexed again from the wrapped store when it is next searched.
+	maxIndexedHistories = 64
+)
+
+// ErrEmptySearch is returned when the text of a search does not contain any words.
+var ErrEmptySearch = errors.New("search does not contain any words")
+
+// SearchQuery is used to search the text of messages in one or more histories. Words in double quotes must appear next to
+// each other, in order. Every word must appear in a message for the message to match.
+type SearchQuery struct {
+	Text      string
+	Histories []string
+	Offset    int
+	Limit     int
+}
+
+// SearchResult is a message matching a search. The higher the score, the better the message matches.
+type SearchResult struct {
+	Score   float64     `json:"score"`
+	Message ChatMessage `json:"message"`
+}
+
+// IndexedStore is a Store that keeps an inverted index of the words in the messages of each history so the text of messages
+// can be searched. A history is indexed from the wrapped store the first time it is searched, so histories kept on disk do
+// not need to be read when the server starts. Only the most recently searched histories are kept indexed, although the index
+// of a single history grows with the history.
+type IndexedStore struct {
+	Store
+	// lock guards the histories that are indexed, not the indexes themselves
+	lock      sync.Mutex
+	histories map[string]*historyIndex
+	searches  uint64
+}
+
+// historyIndex is the inverted index of a single history.
+type historyIndex struct {
+	lock sync.RWMutex
+	// built is set once the messages already in the wrapped store are indexed
+	built bool
+	// building is closed when the index being built is ready, and is nil when the index is not being built
+	building chan struct{}
+	// changed are the IDs of the messages changed while the index is being built, by message ID
+	changed map[int64]bool
+	// lastSearch orders the indexes by when each was last searched, so the least recently searched can be dropped
+	lastSearch uint64
+	// postings are the positions of each word in each message, by message ID
+	postings map[string]map[int64][]int
+	// words are the words of each message, by message ID, so the message can be removed from the postings
+	words map[int64][]string
+	// wordCount is the number of words in every message
+	wordCount int
+}
+
+// indexMatches are the messages of a history matching a search, along with what is needed to score them, so the messages can
+// be scored without holding the lock of the index.
+type indexMatches struct {
+	messageCount int
+	wordCount    int
+	// frequencies are the number of messages containing each term of the search
+	frequencies []int
+	matches     []indexMatch
+}
+
+// indexMatch is a message matching a search with its number of words and the occurrences of each term of the search.
+type indexMatch struct {
+	id          int64
+	length      int
+	occurrences []int
+}
+
+// searchHit is a message matching a search, before the message is retrieved from the store.
+type searchHit struct {
+	history string
+	id      int64
+	score   float64
+}
+
+// NewIndexedStore creates a store that indexes the messages kept in the provided store.
+func NewIndexedStore(store Store) *IndexedStore {
+	return &IndexedStore{
+		Store:     store,
+		lock:      sync.Mutex{},
+		histories: make(map[string]*historyIndex),
+	}
+}
+
+// Append adds the message to the history the message is kept in and indexes the words of the message if the history is
+// indexed.
+func (store *IndexedStore) Append(message ChatMessage) (ChatMessage, error) {
+	stored, err := store.Store.Append(message)
+	if err != nil {
+		return stored, err
+	}
+	if index := store.indexedHistory(stored.History()); index != nil {
+		index.change(stored)
+	}
+	return stored, nil
+}
+
+// Amend changes the message in the named history and indexes the words of the changed message if the history is indexed.
+func (store *IndexedStore) Amend(history string, amendment Amendment) (ChatMessage, error) {
+	amended, err := store.Store.Amend(history, amendment)
+	if err != nil || amendment.IsReaction() {
+		return amended, err
+	}
+	if index := store.indexedHistory(history); index != nil {
+		//
+		// Amendments of the same message can finish in any order, so the latest value is retrieved while holding the lock
+		// of the index
+		//
+		index.lock.Lock()
+		defer index.lock.Unlock()
+		if index.built {
+			latest, err := store.Store.Get(history, amendment.ID)
+			if err != nil {
+				return amended, errors.Wrapf(err, "failed to index message %d of %s", amendment.ID, history)
+			}
+			index.add(latest)
+		} else if index.building != nil {
+			index.changed[amendment.ID] = true
+		}
+	}
+	return amended, nil
+}
+
+// Search retrieves the messages in the histories of the query that match the text of the query, best match first. Along with
+// the page of results selected by the offset and limit of the query, the total number of matching messages is returned. A
+// limit of zero returns every match after the offset.
+func (store *IndexedStore) Search(query SearchQuery) ([]SearchResult, int, error) {
+	terms, phrases := parseSearch(query.Text)
+	if len(terms) == 0 {
+		return nil, 0, errors.Wrapf(ErrEmptySearch, "failed to search for '%s'", query.Text)
+	}
+	hits, err := store.search(query.Histories, terms, phrases)
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		} else if hits[i].id != hits[j].id {
+			return hits[i].id > hits[j].id
+		}
+		return hits[i].history < hits[j].history
+	})
+	total := len(hits)
+	if query.Offset >= total {
+		return []SearchResult{}, total, nil
+	}
+	hits = hits[query.Offset:]
+	if query.Limit > 0 && query.Limit < len(hits) {
+		hits = hits[:query.Limit]
+	}
+	results := make([]SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		chatMessage, err := store.Store.Get(hit.history, hit.id)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to retrieve message %d of %s found by the search", hit.id, hit.history)
+		}
+		results = append(results, SearchResult{Score: hit.score, Message: chatMessage})
+	}
+	return results, total, nil
+}
+
+// search finds the messages containing every term and phrase and scores them. Words that are rare in the searched histories
+// add more to the score than common words, and short messages score higher than long messages with the same words.
+func (store *IndexedStore) search(histories []string, terms []string, phrases [][]string) ([]searchHit, error) {
+	//
+	// Each index is only locked while its matches are collected, so searches never hold the lock of more than one index
+	//
+	collected := make(map[string]indexMatches, len(histories))
+	for _, history := range histories {
+		index := store.searchedHistory(history)
+		if err := index.build(store.Store, history); err != nil {
+			return nil, err
+		}
+		collected[history] = index.collect(terms, phrases)
+	}
+	messageCount := 0
+	wordCount := 0
+	frequencies := make([]int, len(terms))
+	for _, matches := range collected {
+		messageCount += matches.messageCount
+		wordCount += matches.wordCount
+		for position, frequency := range matches.frequencies {
+			frequencies[position] += frequency
+		}
+	}
+	if messageCount == 0 {
+		return nil, nil
+	}
+	averageLength := float64(wordCount) / float64(messageCount)
+	var hits []searchHit
+	for history, matches := range collected {
+		for _, match := range matches.matches {
+			score := 0.0
+			for position, occurrences := range match.occurrences {
+				frequency := float64(occurrences)
+				rarity := math.Log(1 + (float64(messageCount)-float64(frequencies[position])+0.5)/(float64(frequencies[position])+0.5))
+				score += rarity * frequency * (scoreSaturation + 1) /
+					(frequency + scoreSaturation*(1-scoreLengthWeight+scoreLengthWeight*float64(match.length)/averageLength))
+			}
+			hits = append(hits, searchHit{history: history, id: match.id, score: score})
+		}
+	}
+	return hits, nil
+}
+
+// indexedHistory retrieves the index of the history, or nil if the history is not indexed.
+func (store *IndexedStore) indexedHistory(history string) *historyIndex {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.histories[history]
+}
+
+// searchedHistory retrieves the index of the history being searched, adding an index that has not been built if the history
+// is not indexed. If too many histories are indexed, the history searched least recently is dropped.
+func (store *IndexedStore) searchedHistory(history string) *historyIndex {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.searches++
+	index, ok := store.histories[history]
+	if !ok {
+		if len(store.histories) >= maxIndexedHistories {
+			oldest := ""
+			for indexedHistory, indexed := range store.histories {
+				if len(oldest) == 0 || indexed.lastSearch < store.histories[oldest].lastSearch {
+					oldest = indexedHistory
+				}
+			}
+			delete(store.histories, oldest)
+		}
+		index = &historyIndex{
+			lock:     sync.RWMutex{},
+			postings: make(map[string]map[int64][]int),
+			words:    make(map[int64][]string),
+		}
+		store.histories[history] = index
+	}
+	index.lastSearch = store.searches
+	return index
+}
+
+// build indexes the messages already in the history if the index has not been built. The history is read from the wrapped
+// store without holding the lock of the index, so messages changed in the meantime are indexed once the history is read.
+// If the index is already being built, build waits for it to be ready.
+func (index *historyIndex) build(store Store, history string) error {
+	for {
+		index.lock.Lock()
+		if index.built {
+			index.lock.Unlock()
+			return nil
+		} else if index.building != nil {
+			building := index.building
+			index.lock.Unlock()
+			<-building
+			continue
+		}
+		building := make(chan struct{})
+		index.building = building
+		index.changed = make(map[int64]bool)
+		index.lock.Unlock()
+		messages, err := store.Query(Query{RoomName: history})
+		index.lock.Lock()
+		err = index.finishBuild(store, history, messages, err)
+		index.building = nil
+		index.changed = nil
+		close(building)
+		index.lock.Unlock()
+		return err
+	}
+}
+
+// finishBuild indexes the messages read from the history and the messages changed while the history was read. The lock of
+// the index must be held.
+func (index *historyIndex) finishBuild(store Store, history string, messages []ChatMessage, err error) error {
+	if err != nil {
+		return errors.Wrapf(err, "failed to index %s", history)
+	}
+	for _, chatMessage := range messages {
+		index.add(chatMessage)
+	}
+	for id := range index.changed {
+		chatMessage, err := store.Get(history, id)
+		if err != nil {
+			return errors.Wrapf(err, "failed to index message %d of %s", id, history)
+		}
+		index.add(chatMessage)
+	}
+	index.built = true
+	return nil
+}
+
+// change indexes the new message, or records that the message changed if the index is being built.
+func (index *historyIndex) change(chatMessage ChatMessage) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	if index.built {
+		index.add(chatMessage)
+	} else if index.building != nil {
+		index.changed[chatMessage.ID] = true
+	}
+}
+
+// add indexes the words of the message, replacing the words previously indexed for the message. Deleted messages have no
+// words, so they are removed from the index.
+func (index *historyIndex) add(chatMessage ChatMessage) {
+	for _, word := range index.words[chatMessage.ID] {
+		delete(index.postings[word], chatMessage.ID)
+		if len(index.postings[word]) == 0 {
+			delete(index.postings, word)
+		}
+	}
+	index.wordCount -= len(index.words[chatMessage.ID])
+	delete(index.words, chatMessage.ID)
+	words := tokenize(chatMessage.Value)
+	if len(words) == 0 {
+		return
+	}
+	for position, word := range words {
+		if index.postings[word] == nil {
+			index.postings[word] = make(map[int64][]int)
+		}
+		index.postings[word][chatMessage.ID] = append(index.postings[word][chatMessage.ID], position)
+	}
+	index.words[chatMessage.ID] = words
+	index.wordCount += len(words)
+}
+
+// collect finds the messages of the index containing every term and phrase, while holding the read lock of the index.
+func (index *historyIndex) collect(terms []string, phrases [][]string) indexMatches {
+	index.lock.RLock()
+	defer index.lock.RUnlock()
+	matches := indexMatches{
+		messageCount: len(index.words),
+		wordCount:    index.wordCount,
+		frequencies:  make([]int, len(terms)),
+	}
+	for position, term := range terms {
+		matches.frequencies[position] = len(index.postings[term])
+	}
+	for id := range index.postings[terms[0]] {
+		if !index.matches(id, terms, phrases) {
+			continue
+		}
+		match := indexMatch{id: id, length: len(index.words[id]), occurrences: make([]int, len(terms))}
+		for position, term := range terms {
+			match.occurrences[position] = len(index.postings[term][id])
+		}
+		matches.matches = append(matches.matches, match)
+	}
+	return matches
+}
+
+// matches checks the message with the ID contains every term and every phrase.
+func (index *historyIndex) matches(id int64, terms []string, phrases [][]string) bool {
+	for _, term := range terms {
+		if len(index.postings[term][id]) == 0 {
+			return false
+		}
+	}
+	for _, phrase := range phrases {
+		if !index.containsPhrase(id, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsPhrase checks the words of the phrase appear next to each other, in order, in the message with the ID.
+func (index *historyIndex) containsPhrase(id int64, phrase []string) bool {
+	for _, start := range index.postings[phrase[0]][id] {
+		found := true
+		for offset, word := range phrase[1:] {
+			if !containsPosition(index.postings[word][id], start+offset+1) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPosition(positions []int, position int) bool {
+	for _, value := range positions {
+		if value == position {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSearch splits the text of a search into its distinct words and the phrases in double quotes. The words of phrases are
+// included in the words.
+func parseSearch(text string) ([]string, [][]string) {
+	var terms []string
+	var phrases [][]string
+	seen := make(map[string]bool)
+	for index, part := range strings.Split(text, string(phraseQuote)) {
+		words := tokenize(part)
+		//
+		// Every other part is inside quotes
+		//
+		if index%2 == 1 && len(words) > 1 {
+			phrases = append(phrases, words)
+		}
+		for _, word := range words {
+			if !seen[word] {
+				seen[word] = true
+				terms = append(terms, word)
+			}
+		}
+	}
+	return terms, phrases
+}
+
+// tokenize splits the text into lower case words made of letters and numbers.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
@@ -0,0 +1,122 @@
+package message
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+func TestIndexedStore_Search(t *testing.T) {
+	store := NewIndexedStore(NewMemoryStore())
+	_, _ = store.Append(ChatMessage{Timestamp: time.Now(), Room: "test", Sender: "tester", Value: "Lunch is at noon"})
+	_, _ = store.Append(ChatMessage{Timestamp: time.Now(), Room: "test", Sender: "tester", Value: "Who is ordering lunch? Lunch!"})
+	_, _ = store.Append(ChatMessage{Timestamp: time.Now(), Room: "other", Sender: "tester", Value: "No lunch today"})
+	_, _ = store.Append(ChatMessage{Timestamp: time.Now(), Room: "test", Sender: "tester", Value: "Standup is at ten"})
+	results, total, err := store.Search(SearchQuery{Text: "LUNCH", Histories: []string{"test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(results) != 2 || results[0].Message.ID != 2 || results[0].Score <= results[1].Score {
+		t.Fatalf("expected the message repeating the word to be the best match. Actual results %+v", results)
+	}
+	results, total, err = store.Search(SearchQuery{Text: "lunch", Histories: []string{"test", "other"}, Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(results) != 1 {
+		t.Fatalf("expected a page of 1 of the 3 matching messages. Actual total %d and results %+v", total, results)
+	}
+	results, _, err = store.Search(SearchQuery{Text: `"is at" noon`, Histories: []string{"test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Message.ID != 1 {
+		t.Fatalf("expected only the message with the phrase and the word to match. Actual results %+v", results)
+	}
+	if results, _, _ = store.Search(SearchQuery{Text: `"at is"`, Histories: []string{"test"}}); len(results) != 0 {
+		t.Fatalf("expected the words of the phrase to be in order. Actual results %+v", results)
+	}
+	if _, _, err = store.Search(SearchQuery{Text: `" ?"`, Histories: []string{"test"}}); errors.Cause(err) != ErrEmptySearch {
+		t.Fatalf("expected a search without words to fail. Actual error %v", err)
+	}
+}
+
+func TestIndexedStore_Amend(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	_, _ = memoryStore.Append(ChatMessage{Timestamp: time.Now(), Room: "test", Sender: "tester", Value: "Helo world"})
+	store := NewIndexedStore(memoryStore)
+	results, _, err := store.Search(SearchQuery{Text: "helo", Histories: []string{"test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected the existing history to be indexed. Actual results %+v", results)
+	}
+	if _, err = store.Amend("test", Amendment{ID: 1, Time: time.Now(), Value: "Hello world"}); err != nil {
+		t.Fatal(err)
+	}
+	if results, _, _ = store.Search(SearchQuery{Text: "helo", Histories: []string{"test"}}); len(results) != 0 {
+		t.Fatalf("expected the previous value to no longer match. Actual results %+v", results)
+	}
+	if results, _, _ = store.Search(SearchQuery{Text: "hello", Histories: []string{"test"}}); len(results) != 1 {
+		t.Fatalf("expected the edited value to match. Actual results %+v", results)
+	}
+	if _, err = store.Amend("test", Amendment{ID: 1, Time: time.Now(), Deleted: true}); err != nil {
+		t.Fatal(err)
+	}
+	if results, _, _ = store.Search(SearchQuery{Text: "world", Histories: []string{"test"}}); len(results) != 0 {
+		t.Fatalf("expected deleted messages to not match. Actual results %+v", results)
+	}
+}
+
+func TestIndexedStore_maxIndexedHistories(t *testing.T) {
+	store := NewIndexedStore(NewMemoryStore())
+	for index := 0; index <= maxIndexedHistories; index++ {
+		history := fmt.Sprintf("room%d", index)
+		_, _ = store.Append(ChatMessage{Timestamp: time.Now(), Room: history, Sender: "tester", Value: "Coffee is ready"})
+		if _, _, err := store.Search(SearchQuery{Text: "coffee", Histories: []string{history}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(store.histories) != maxIndexedHistories || store.indexedHistory("room0") != nil {
+		t.Fatalf("expected the history searched least recently to be dropped. Actual indexed histories %d", len(store.histories))
+	}
+	_, _ = store.Append(ChatMessage{Timestamp: time.Now(), Room: "room0", Sender: "tester", Value: "Coffee is gone"})
+	results, _, err := store.Search(SearchQuery{Text: "coffee", Histories: []string{"room0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected the dropped history to be indexed again from the store. Actual results %+v", results)
+	}
+}
+
+func TestIndexedStore_Search_concurrent(t *testing.T) {
+	store := NewIndexedStore(NewMemoryStore())
+	done := make(chan struct{})
+	for _, histories := range [][]string{{"first", "second"}, {"second", "first"}} {
+		go func(histories []string) {
+			defer func() {
+				done <- struct{}{}
+			}()
+			for index := 0; index < 100; index++ {
+				_, _ = store.Append(ChatMessage{Timestamp: time.Now(), Room: histories[0], Sender: "tester", Value: "Deploy now"})
+				if _, _, err := store.Search(SearchQuery{Text: "deploy", Histories: histories}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(histories)
+	}
+	for index := 0; index < 2; index++ {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("expected searches of the same histories in a different order to finish")
+		}
+	}
+	if _, total, _ := store.Search(SearchQuery{Text: "deploy", Histories: []string{"first", "second"}}); total != 200 {
+		t.Fatalf("expected every message to be indexed. Actual total %d", total)
+	}
+}
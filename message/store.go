@@ -0,0 +1,167 @@
+package message
+
+import (
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// ErrMessageNotFound is returned when a message with the requested ID is not in the history.
+var ErrMessageNotFound = errors.New("message not found")
+
+// Store persists the messages sent to rooms and between users so the history of a room or conversation can be queried.
+type Store interface {
+	// Append adds the message to the history the message is kept in. The stored message is returned with the ID it was
+	// given, which is one more than the ID of the previous message in the history.
+	Append(message ChatMessage) (ChatMessage, error)
+	// Get retrieves the message with the ID from the named history.
+	Get(history string, id int64) (ChatMessage, error)
+	// Amend records the change to the message in the named history and returns the changed message.
+	Amend(history string, amendment Amendment) (ChatMessage, error)
+	// Query retrieves the messages from the history named by the query's room that match the query.
+	Query(query Query) ([]ChatMessage, error)
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// Amendment is a change made to a message after it was sent. An amendment either edits the value of the message, deletes
+// the message, or adds or removes the reaction of a user to the message.
+type Amendment struct {
+	ID       int64     `json:"id"`
+	Time     time.Time `json:"time"`
+	Value    string    `json:"value,omitempty"`
+	Deleted  bool      `json:"deleted,omitempty"`
+	Reaction string    `json:"reaction,omitempty"`
+	User     string    `json:"user,omitempty"`
+	Removed  bool      `json:"removed,omitempty"`
+}
+
+// IsReaction determines if the amendment adds or removes a reaction rather than changing the message itself.
+func (amendment Amendment) IsReaction() bool {
+	return len(amendment.Reaction) != 0
+}
+
+// Apply changes the message by the amendment. Deleted messages keep their ID, sender, and time, but lose their value and
+// reactions.
+func (amendment Amendment) Apply(message ChatMessage) ChatMessage {
+	if message.Deleted {
+		return message
+	}
+	if amendment.Deleted {
+		message.Value = ""
+		message.Deleted = true
+		message.Edited = nil
+		message.Reactions = nil
+		return message
+	} else if amendment.IsReaction() {
+		message.Reactions = amendment.react(message.Reactions)
+		return message
+	}
+	edited := amendment.Time
+	message.Value = amendment.Value
+	message.Edited = &edited
+	return message
+}
+
+// react adds or removes the user of the amendment from the users of the reaction. The reactions are copied, as they may be
+// shared with the stored message. Reactions without users are removed.
+func (amendment Amendment) react(reactions []Reaction) []Reaction {
+	var updated []Reaction
+	found := false
+	for _, reaction := range reactions {
+		if reaction.Value == amendment.Reaction {
+			found = true
+			users := make([]string, 0, len(reaction.Users)+1)
+			for _, user := range reaction.Users {
+				if user != amendment.User {
+					users = append(users, user)
+				}
+			}
+			if !amendment.Removed {
+				users = append(users, amendment.User)
+			}
+			reaction.Users = users
+			reaction.Count = len(users)
+		}
+		if reaction.Count > 0 {
+			updated = append(updated, reaction)
+		}
+	}
+	if !found && !amendment.Removed {
+		updated = append(updated, Reaction{Value: amendment.Reaction, Count: 1, Users: []string{amendment.User}})
+	}
+	return updated
+}
+
+// MemoryStore is a Store that keeps messages in memory. Messages are lost when the server is stopped.
+type MemoryStore struct {
+	lock     sync.RWMutex
+	messages map[string][]ChatMessage
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		lock:     sync.RWMutex{},
+		messages: make(map[string][]ChatMessage),
+	}
+}
+
+// Append adds the message to the history the message is kept in.
+func (store *MemoryStore) Append(message ChatMessage) (ChatMessage, error) {
+	store.lock.Lock()
+	history := message.History()
+	message.ID = int64(len(store.messages[history]) + 1)
+	store.messages[history] = append(store.messages[history], message)
+	store.lock.Unlock()
+	return message, nil
+}
+
+// Get retrieves the message with the ID from the named history.
+func (store *MemoryStore) Get(history string, id int64) (ChatMessage, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	messages := store.messages[history]
+	if id < 1 || id > int64(len(messages)) {
+		return ChatMessage{}, errors.Wrapf(ErrMessageNotFound, "failed to find message %d in %s", id, history)
+	}
+	return messages[id-1], nil
+}
+
+// Amend changes the message in the named history.
+func (store *MemoryStore) Amend(history string, amendment Amendment) (ChatMessage, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	messages := store.messages[history]
+	if amendment.ID < 1 || amendment.ID > int64(len(messages)) {
+		return ChatMessage{}, errors.Wrapf(ErrMessageNotFound, "failed to find message %d in %s", amendment.ID, history)
+	}
+	messages[amendment.ID-1] = amendment.Apply(messages[amendment.ID-1])
+	return messages[amendment.ID-1], nil
+}
+
+// Query retrieves the messages in the room's history matching the query.
+func (store *MemoryStore) Query(query Query) ([]ChatMessage, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	messages := store.messages[query.RoomName]
+	matchingMessages := make([]ChatMessage, 0)
+	for index := range messages {
+		chatMessage := messages[index]
+		if query.Descending {
+			chatMessage = messages[len(messages)-1-index]
+		}
+		if query.Matches(chatMessage) {
+			matchingMessages = append(matchingMessages, chatMessage)
+			if query.IsFull(matchingMessages) {
+				break
+			}
+		}
+	}
+	return matchingMessages, nil
+}
+
+// Close does nothing as there is nothing to release.
+func (store *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,103 @@
+package message
+
+import (
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_Query(t *testing.T) {
+	store := NewMemoryStore()
+	_, _ = store.Append(ChatMessage{Timestamp: time.Now(), Room: "test", Sender: "tester", Value: "Hello"})
+	_, _ = store.Append(ChatMessage{Timestamp: time.Now(), Room: "other", Sender: "tester", Value: "Hello"})
+	messages, err := store.Query(Query{RoomName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message in room 'test'. Actual size %d", len(messages))
+	}
+}
+
+func TestMemoryStore_Query_page(t *testing.T) {
+	store := NewMemoryStore()
+	for i := 0; i < 5; i++ {
+		_, _ = store.Append(ChatMessage{Timestamp: time.Now(), Room: "test", Sender: "tester", Value: "Hello"})
+	}
+	messages, err := store.Query(Query{RoomName: "test", BeforeID: 5, Limit: 2, Descending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].ID != 4 || messages[1].ID != 3 {
+		t.Fatalf("expected the 2 messages before message 5, newest first. Actual messages %+v", messages)
+	}
+}
+
+func TestQuery_Matches(t *testing.T) {
+	chatMessage := ChatMessage{
+		Timestamp: time.Date(2019, 1, 1, 1, 1, 1, 0, time.UTC),
+		Room:      "test",
+		Sender:    "tester",
+		Value:     "Hello from tester!",
+	}
+	if !(Query{}).Matches(chatMessage) {
+		t.Fatal("expected empty query to match the message")
+	}
+	if (Query{SenderName: "tester1"}).Matches(chatMessage) {
+		t.Fatal("expected query for a different sender to not match the message")
+	}
+	if (Query{Start: chatMessage.Timestamp}).Matches(chatMessage) {
+		t.Fatal("expected query starting at the message timestamp to not match the message")
+	}
+	if (Query{End: chatMessage.Timestamp}).Matches(chatMessage) {
+		t.Fatal("expected query ending at the message timestamp to not match the message")
+	}
+	chatMessage.ID = 2
+	if (Query{AfterID: 2}).Matches(chatMessage) || (Query{BeforeID: 2}).Matches(chatMessage) {
+		t.Fatal("expected query after or before the message ID to not match the message")
+	}
+}
+
+func TestMemoryStore_Amend(t *testing.T) {
+	store := NewMemoryStore()
+	first, _ := store.Append(ChatMessage{Timestamp: time.Now(), Room: "test", Sender: "tester", Value: "Hello"})
+	second, _ := store.Append(ChatMessage{Timestamp: time.Now(), Room: "test", Sender: "tester", Value: "Helo"})
+	if first.ID != 1 || second.ID != 2 {
+		t.Fatalf("expected messages to be numbered in order. Actual IDs %d and %d", first.ID, second.ID)
+	}
+	edited, err := store.Amend("test", Amendment{ID: second.ID, Time: time.Now(), Value: "Hello again"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Value != "Hello again" || edited.Edited == nil {
+		t.Fatalf("expected the message to be edited. Actual message %+v", edited)
+	}
+	if _, err = store.Amend("test", Amendment{ID: first.ID, Time: time.Now(), Deleted: true}); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, _ := store.Get("test", first.ID); !deleted.Deleted || len(deleted.Value) != 0 {
+		t.Fatalf("expected the message to be deleted. Actual message %+v", deleted)
+	}
+	if _, err = store.Get("test", 3); errors.Cause(err) != ErrMessageNotFound {
+		t.Fatalf("expected a missing message to not be found. Actual error %+v", err)
+	}
+}
+
+func TestAmendment_Apply_reaction(t *testing.T) {
+	chatMessage := ChatMessage{ID: 1, Timestamp: time.Now(), Room: "test", Sender: "tester", Value: "Hello"}
+	chatMessage = Amendment{ID: 1, Reaction: ":+1:", User: "tester1"}.Apply(chatMessage)
+	chatMessage = Amendment{ID: 1, Reaction: ":+1:", User: "tester2"}.Apply(chatMessage)
+	chatMessage = Amendment{ID: 1, Reaction: ":+1:", User: "tester2"}.Apply(chatMessage)
+	chatMessage = Amendment{ID: 1, Reaction: ":tada:", User: "tester1"}.Apply(chatMessage)
+	if summary := chatMessage.ReactionSummary(); summary != ":+1: 2, :tada: 1" {
+		t.Fatalf("expected users to react once with each reaction. Actual reactions %s", summary)
+	}
+	reacted := chatMessage
+	chatMessage = Amendment{ID: 1, Reaction: ":tada:", User: "tester1", Removed: true}.Apply(chatMessage)
+	if summary := chatMessage.ReactionSummary(); summary != ":+1: 2" {
+		t.Fatalf("expected reactions without users to be removed. Actual reactions %s", summary)
+	}
+	if summary := reacted.ReactionSummary(); summary != ":+1: 2, :tada: 1" {
+		t.Fatalf("expected the previous reactions to be left unchanged. Actual reactions %s", summary)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	commandKick          = "-kick"
+	commandBan           = "-ban"
+	commandUnban         = "-unban"
+	commandListBans      = "-lbans"
+	commandModerator     = "-mod"
+	commandUnmoderator   = "-unmod"
+	banOptionIP          = "ip"
+	messageNotModerator  = "Only moderators of the room can use %s."
+	messageNotOwner      = "Only the owner of the room can use %s."
+	messageUserNotInRoom = "%s is not in the room."
+)
+
+var errBanned = errors.New("user is banned from the room")
+
+// Ban prevents a user from entering a room. A ban with an address also prevents anyone at the address from entering.
+type Ban struct {
+	Name    string    `json:"name"`
+	Address string    `json:"address,omitempty"`
+	By      string    `json:"by"`
+	Created time.Time `json:"created"`
+}
+
+// IsOwner checks if the user owns the room. Admins of the server are treated as owners of every room.
+func (room *ChatRoom) IsOwner(userName string) bool {
+	return (len(room.Owner) != 0 && room.Owner == userName) || server.IsAdmin(userName)
+}
+
+// IsModerator checks if the user can moderate the room. Owners can always moderate their room.
+func (room *ChatRoom) IsModerator(userName string) bool {
+	room.moderationLock.RLock()
+	moderator := room.moderators[userName]
+	room.moderationLock.RUnlock()
+	return moderator || room.IsOwner(userName)
+}
+
+// SetModerator grants or revokes the moderator role of the user.
+func (room *ChatRoom) SetModerator(userName string, moderator bool) {
+	room.moderationLock.Lock()
+	if moderator {
+		room.moderators[userName] = true
+	} else {
+		delete(room.moderators, userName)
+	}
+	room.moderationLock.Unlock()
+	server.saveStateLater()
+}
+
+// GetModerators retrieves the names of the moderators of the room, not including the owner.
+func (room *ChatRoom) GetModerators() []string {
+	room.moderationLock.RLock()
+	defer room.moderationLock.RUnlock()
+	moderators := make([]string, 0, len(room.moderators))
+	for moderator := range room.moderators {
+		moderators = append(moderators, moderator)
+	}
+	return moderators
+}
+
+// Ban adds the ban to the room. Any invitation of the banned user is withdrawn.
+func (room *ChatRoom) Ban(ban Ban) {
+	room.moderationLock.Lock()
+	room.bans = append(room.bans, ban)
+	delete(room.invited, ban.Name)
+	room.moderationLock.Unlock()
+	logger.Printf("%s banned %s from the room %s\n", ban.By, ban.Name, room.Name)
+	server.saveStateLater()
+}
+
+// Unban removes every ban of the user from the room. Returns false if the user was not banned.
+func (room *ChatRoom) Unban(userName string) bool {
+	room.moderationLock.Lock()
+	bans := room.bans[:0]
+	for _, ban := range room.bans {
+		if ban.Name != userName {
+			bans = append(bans, ban)
+		}
+	}
+	unbanned := len(bans) != len(room.bans)
+	room.bans = bans
+	room.moderationLock.Unlock()
+	if unbanned {
+		logger.Printf("%s is no longer banned from the room %s\n", userName, room.Name)
+		server.saveStateLater()
+	}
+	return unbanned
+}
+
+// GetBans retrieves the bans of the room.
+func (room *ChatRoom) GetBans() []Ban {
+	room.moderationLock.RLock()
+	defer room.moderationLock.RUnlock()
+	return append([]Ban(nil), room.bans...)
+}
+
+// IsBanned checks if the user's name or address is banned from the room.
+func (room *ChatRoom) IsBanned(userName string, address string) bool {
+	room.moderationLock.RLock()
+	defer room.moderationLock.RUnlock()
+	for _, ban := range room.bans {
+		if ban.Name == userName || (len(ban.Address) != 0 && ban.Address == address) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasUser checks if the user is in the room.
+func (room *ChatRoom) HasUser(userName string) bool {
+	for _, name := range room.GetUsers() {
+		if name == userName {
+			return true
+		}
+	}
+	return false
+}
+
+// Kick removes the user from the room, letting the user and the rest of the room know who removed them.
+func (room *ChatRoom) Kick(userName string, by string) {
+	room.RemoveUser(userName)
+	if user := server.GetUser(userName); user != nil {
+		user.ReceiveMessage(fmt.Sprintf("You have been removed from the room %s by %s. Use %s to enter another room.", room.Name, by, commandChangeRoom))
+	}
+	room.Broadcast(fmt.Sprintf("%s has been removed by %s", userName, by))
+}
+
+// moderate handles the moderation commands. Only moderators of the room can kick and ban users, and only the owner of the
+// room can grant or revoke the moderator role.
+func (user *ChatUser) moderate(command string, msg string, room *ChatRoom) {
+	parts := strings.Fields(msg)
+	if command == commandModerator || command == commandUnmoderator {
+		if !room.IsOwner(user.Name) {
+			user.ReceiveMessage(fmt.Sprintf(messageNotOwner, command))
+			return
+		}
+	} else if !room.IsModerator(user.Name) {
+		user.ReceiveMessage(fmt.Sprintf(messageNotModerator, command))
+		return
+	}
+	if command == commandListBans {
+		user.listBans(room)
+		return
+	}
+	if len(parts) < 2 {
+		user.ReceiveMessage("A user Name is required.")
+		return
+	}
+	userName := parts[1]
+	switch command {
+	case commandKick:
+		if !room.HasUser(userName) {
+			user.ReceiveMessage(fmt.Sprintf(messageUserNotInRoom, userName))
+		} else if room.IsOwner(userName) {
+			user.ReceiveMessage("The owner of the room cannot be removed.")
+		} else {
+			room.Kick(userName, user.Name)
+		}
+	case commandBan:
+		user.ban(room, userName, len(parts) > 2 && parts[2] == banOptionIP)
+	case commandUnban:
+		if room.Unban(userName) {
+			user.ReceiveMessage(fmt.Sprintf("%s is no longer banned from the room.", userName))
+		} else {
+			user.ReceiveMessage(fmt.Sprintf("%s is not banned from the room.", userName))
+		}
+	case commandModerator:
+		room.SetModerator(userName, true)
+		room.Broadcast(fmt.Sprintf("%s is now a moderator", userName))
+	case commandUnmoderator:
+		room.SetModerator(userName, false)
+		room.Broadcast(fmt.Sprintf("%s is no longer a moderator", userName))
+	}
+}
+
+func (user *ChatUser) ban(room *ChatRoom, userName string, byAddress bool) {
+	if room.IsOwner(userName) {
+		user.ReceiveMessage("The owner of the room cannot be banned.")
+		return
+	}
+	ban := Ban{
+		Name:    userName,
+		By:      user.Name,
+		Created: time.Now(),
+	}
+	bannedUser := server.GetUser(userName)
+	if byAddress && bannedUser != nil && len(bannedUser.Address) != 0 {
+		ban.Address = bannedUser.Address
+	} else if byAddress {
+		user.ReceiveMessage(fmt.Sprintf("The address of %s is not known. Banning by Name only.", userName))
+	}
+	room.Ban(ban)
+	user.ReceiveMessage(fmt.Sprintf("%s has been banned from the room.", userName))
+	if room.HasUser(userName) {
+		room.Kick(userName, user.Name)
+	}
+}
+
+func (user *ChatUser) listBans(room *ChatRoom) {
+	bans := room.GetBans()
+	lines := make([]string, len(bans))
+	for index, ban := range bans {
+		banned := ban.Name
+		if len(ban.Address) != 0 {
+			banned = fmt.Sprintf("%s (%s)", ban.Name, ban.Address)
+		}
+		lines[index] = fmt.Sprintf("%s banned by %s on %s", banned, ban.By, ban.Created.Format(time.RFC1123))
+	}
+	user.ReceiveMessage(fmt.Sprintf("Users banned from the room:\n%s", strings.Join(lines, "\n")))
+}
+
+// hostOf returns the IP address of a network address, such as the remote address of a connection. If the address does
+// not have a port, the address is returned as is.
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
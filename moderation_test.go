@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChatServer_OpenRoom(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	unownedRoom, err := server.OpenRoom("unownedRoom", "owner", "10.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unownedRoom.Owner) != 0 {
+		t.Fatalf("expected a name that is not registered to not own the room. Actual owner %s", unownedRoom.Owner)
+	}
+	if err = server.RegisterAccount("owner", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	room, err := server.OpenRoom("modRoom", "owner", "10.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.Owner != "owner" {
+		t.Fatalf("expected the user who created the room to own it. Actual owner %s", room.Owner)
+	}
+	room.Ban(Ban{Name: "banned", Address: "10.0.0.2", By: "owner"})
+	if _, err = server.OpenRoom("modRoom", "banned", "10.0.0.3", ""); errors.Cause(err) != errBanned {
+		t.Fatalf("expected the banned name to be rejected. Actual error %+v", err)
+	}
+	if _, err = server.OpenRoom("modRoom", "other", "10.0.0.2", ""); errors.Cause(err) != errBanned {
+		t.Fatalf("expected the banned address to be rejected. Actual error %+v", err)
+	}
+	if _, err = server.OpenRoom("modRoom", "other", "10.0.0.3", ""); err != nil {
+		t.Fatalf("expected a user that is not banned to enter the room: %+v", err)
+	}
+	if room = server.GetRoom("modRoom"); room.Owner != "owner" {
+		t.Fatalf("expected entering the room to not change the owner. Actual owner %s", room.Owner)
+	}
+}
+
+func TestChatUser_moderate(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var ownerOutput, guestOutput, troublemakerOutput bytes.Buffer
+	owner := ChatUser{Name: "modOwner", writer: &ownerOutput}
+	guest := ChatUser{Name: "modGuest", writer: &guestOutput}
+	troublemaker := ChatUser{Name: "modTroublemaker", Address: "10.0.0.4", writer: &troublemakerOutput}
+	if err := server.RegisterAccount("modOwner", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	room, err := server.OpenRoom("modRoom", owner.Name, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []*ChatUser{&owner, &guest, &troublemaker} {
+		server.AddUser(user)
+		room.AddUser(user.Name)
+	}
+	//
+	// Only moderators can kick
+	//
+	guest.handleInput("-kick modTroublemaker", room)
+	if !room.HasUser(troublemaker.Name) {
+		t.Fatal("expected a user that is not a moderator to not be able to kick")
+	}
+	owner.handleInput("-mod modGuest", room)
+	if !room.IsModerator(guest.Name) {
+		t.Fatal("expected the owner to be able to make a moderator")
+	}
+	guest.handleInput("-kick modTroublemaker", room)
+	if room.HasUser(troublemaker.Name) {
+		t.Fatal("expected the moderator to be able to kick")
+	}
+	if !strings.Contains(troublemakerOutput.String(), "You have been removed from the room modRoom by modGuest.") {
+		t.Fatalf("kicked user was not told they were removed. Actual output %s", troublemakerOutput.String())
+	}
+	//
+	// Bans by IP address also apply to other names
+	//
+	guest.handleInput("-ban modTroublemaker ip", room)
+	if !room.IsBanned("someoneElse", troublemaker.Address) {
+		t.Fatal("expected the address of the banned user to be banned")
+	}
+	guest.handleInput("-ban modOwner", room)
+	if room.IsBanned(owner.Name, "") {
+		t.Fatal("expected the owner to not be bannable")
+	}
+	owner.handleInput("-unban modTroublemaker", room)
+	if room.IsBanned(troublemaker.Name, troublemaker.Address) {
+		t.Fatal("expected the ban to be lifted")
+	}
+}
+
+func TestHandleRoomRequest_PostMessage_Banned(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	server.AllowSenderName(true)
+	defer func() {
+		server = CreateServer()
+	}()
+	room, err := server.OpenRoom("main", "owner", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.Ban(Ban{Name: "tester", By: "owner"})
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}", RoomRequestHandler)
+	req, err := http.NewRequest(http.MethodPost, "/rooms/main", bytes.NewBufferString("Message posted from test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Sender-Name", "tester")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+}
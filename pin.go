@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	commandPin   = "-pin"
+	commandUnpin = "-unpin"
+	commandPins  = "-pins"
+	pathRoomPins = "/rooms/{name}/pins"
+	maxPins      = 50
+)
+
+var (
+	errAlreadyPinned = errors.New("message is already pinned")
+	errTooManyPins   = errors.New("room has too many pinned messages")
+)
+
+// Pin is a message of a room that has been pinned so it is easy to find, such as an announcement.
+type Pin struct {
+	ID       int64     `json:"id"`
+	PinnedBy string    `json:"pinnedBy"`
+	Pinned   time.Time `json:"pinned"`
+}
+
+// pinnedMessage is a pinned message returned by the pins endpoint.
+type pinnedMessage struct {
+	Pin
+	Message message.ChatMessage `json:"message"`
+}
+
+// Pin pins the message with the ID to the room. Deleted messages cannot be pinned.
+func (room *ChatRoom) Pin(id int64, pinnedBy string) error {
+	chatMessage, err := room.GetMessage(id)
+	if err != nil {
+		return err
+	} else if chatMessage.Deleted {
+		return errors.Wrapf(errMessageDeleted, "failed to pin message %d of room %s", id, room.Name)
+	}
+	room.pinLock.Lock()
+	for _, pin := range room.pins {
+		if pin.ID == id {
+			room.pinLock.Unlock()
+			return errors.Wrapf(errAlreadyPinned, "failed to pin message %d of room %s", id, room.Name)
+		}
+	}
+	if len(room.pins) >= maxPins {
+		room.pinLock.Unlock()
+		return errors.Wrapf(errTooManyPins, "failed to pin message %d of room %s", id, room.Name)
+	}
+	room.pins = append(room.pins, Pin{ID: id, PinnedBy: pinnedBy, Pinned: time.Now()})
+	room.pinLock.Unlock()
+	logger.Printf("%s pinned message %d of room %s\n", pinnedBy, id, room.Name)
+	server.saveStateLater()
+	return nil
+}
+
+// Unpin removes the message with the ID from the pinned messages of the room. Returns false if the message was not pinned.
+func (room *ChatRoom) Unpin(id int64) bool {
+	room.pinLock.Lock()
+	unpinned := false
+	for index, pin := range room.pins {
+		if pin.ID == id {
+			room.pins = append(room.pins[:index], room.pins[index+1:]...)
+			unpinned = true
+			break
+		}
+	}
+	room.pinLock.Unlock()
+	if unpinned {
+		logger.Printf("Message %d of room %s was unpinned\n", id, room.Name)
+		server.saveStateLater()
+	}
+	return unpinned
+}
+
+// GetPins retrieves the pinned messages of the room, in the order they were pinned.
+func (room *ChatRoom) GetPins() []Pin {
+	room.pinLock.RLock()
+	defer room.pinLock.RUnlock()
+	return append([]Pin(nil), room.pins...)
+}
+
+// GetPinnedMessages retrieves the pinned messages of the room from the room's history, in the order they were pinned.
+func (room *ChatRoom) GetPinnedMessages() ([]pinnedMessage, error) {
+	pins := room.GetPins()
+	pinnedMessages := make([]pinnedMessage, 0, len(pins))
+	for _, pin := range pins {
+		chatMessage, err := room.GetMessage(pin.ID)
+		if errors.Cause(err) == message.ErrMessageNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		pinnedMessages = append(pinnedMessages, pinnedMessage{Pin: pin, Message: chatMessage})
+	}
+	return pinnedMessages, nil
+}
+
+// pin pins or unpins the message with the ID in the message. Only moderators of the room can pin messages.
+func (user *ChatUser) pin(command string, msg string, room *ChatRoom) {
+	if !room.IsModerator(user.Name) {
+		user.ReceiveMessage(fmt.Sprintf(messageNotModerator, command))
+		return
+	}
+	parts := strings.Fields(msg)
+	if len(parts) < 2 {
+		user.ReceiveMessage("A message ID is required.")
+		return
+	}
+	id, err := parseMessageID(parts[1])
+	if err != nil {
+		user.ReceiveMessage(fmt.Sprintf("%s is not a message ID.", parts[1]))
+		return
+	}
+	if command == commandUnpin {
+		if room.Unpin(id) {
+			room.Broadcast(fmt.Sprintf("%s unpinned message #%d", user.Name, id))
+		} else {
+			user.ReceiveMessage(fmt.Sprintf("Message #%d is not pinned.", id))
+		}
+		return
+	}
+	err = room.Pin(id, user.Name)
+	switch errors.Cause(err) {
+	case nil:
+		room.Broadcast(fmt.Sprintf("%s pinned message #%d", user.Name, id))
+	case errAlreadyPinned:
+		user.ReceiveMessage(fmt.Sprintf("Message #%d is already pinned.", id))
+	case errTooManyPins:
+		user.ReceiveMessage(fmt.Sprintf("The room already has %d pinned messages. Use %s to unpin a message first.", maxPins, commandUnpin))
+	default:
+		user.receiveMessageError(id, err)
+	}
+}
+
+// listPins lets the user know of the pinned messages of the room.
+func (user *ChatUser) listPins(room *ChatRoom) {
+	if len(room.GetPins()) == 0 {
+		user.ReceiveMessage("The room has no pinned messages.")
+		return
+	}
+	user.showPins(room)
+}
+
+// showPins lets the user know of the pinned messages of the room, if the room has any.
+func (user *ChatUser) showPins(room *ChatRoom) {
+	pinnedMessages, err := room.GetPinnedMessages()
+	if err != nil {
+		logger.Printf("ERROR: failed to get the pinned messages of room %s: %+v\n", room.Name, err)
+		return
+	}
+	if len(pinnedMessages) == 0 {
+		return
+	}
+	lines := make([]string, len(pinnedMessages))
+	for index, pinned := range pinnedMessages {
+		lines[index] = pinned.Message.RoomMessage()
+	}
+	user.ReceiveMessage(fmt.Sprintf("Pinned messages:\n%s", strings.Join(lines, "\n")))
+}
+
+// RoomPinsHandler handles listing the pinned messages of the room in the path. Invite only and password protected rooms
+// require the same access as entering the room.
+func RoomPinsHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	roomName := mux.Vars(request)[pathVariableName]
+	logger.Println("Received HTTP request for the pinned messages of room " + roomName)
+	if !authorizeRead(writer, request) {
+		return
+	}
+	room := server.FindRoom(roomName)
+	if room == nil {
+		writeRoomNotFound(writer, roomName)
+		return
+	}
+	if !authorizeRoom(writer, request, room) {
+		return
+	}
+	pinnedMessages, err := room.GetPinnedMessages()
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		logger.Printf("ERROR: failed to get the pinned messages of room %s: %+v\n", roomName, err)
+		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to query messages"}`)
+		return
+	}
+	writeJSON(writer, http.StatusOK, pinnedMessages)
+}
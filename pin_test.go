@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatRoom_Pin(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	room := server.CreateRoomIfMissing("pinRoom")
+	room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "pinRoom", Sender: "tester", Value: "Read the rules"})
+	room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "pinRoom", Sender: "tester", Value: "Oops"})
+	if err := room.Pin(1, "tester"); err != nil {
+		t.Fatal(err)
+	}
+	if err := room.Pin(1, "tester"); errors.Cause(err) != errAlreadyPinned {
+		t.Fatalf("expected a pinned message to not be pinned again. Actual error %v", err)
+	}
+	if err := room.Pin(3, "tester"); errors.Cause(err) != message.ErrMessageNotFound {
+		t.Fatalf("expected a missing message to not be pinned. Actual error %v", err)
+	}
+	if err := room.Pin(2, "tester"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := room.DeleteMessage(2, "tester"); err != nil {
+		t.Fatal(err)
+	}
+	pinnedMessages, err := room.GetPinnedMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pinnedMessages) != 1 || pinnedMessages[0].Message.Value != "Read the rules" || pinnedMessages[0].PinnedBy != "tester" {
+		t.Fatalf("expected only the message that was not deleted to be pinned. Actual pins %+v", pinnedMessages)
+	}
+	if !room.Unpin(1) || room.Unpin(1) || len(room.GetPins()) != 0 {
+		t.Fatalf("expected the message to be unpinned once. Actual pins %+v", room.GetPins())
+	}
+}
+
+func TestChatUser_handleInput_pin(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var b bytes.Buffer
+	user := ChatUser{Name: "pinTester", writer: &b}
+	server.AddUser(&user)
+	room := server.CreateRoomIfMissing("pinCommandRoom")
+	room.AddUser(user.Name)
+	room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "pinCommandRoom", Sender: "tester", Value: "Standup at 10"})
+	user.handleInput("-pin 1", room)
+	if !strings.Contains(b.String(), "Only moderators of the room can use -pin.") || len(room.GetPins()) != 0 {
+		t.Fatalf("expected only moderators to pin messages. Actual output %s", b.String())
+	}
+	user.handleInput("-pins", room)
+	if !strings.Contains(b.String(), "The room has no pinned messages.") {
+		t.Fatalf("expected the room to have no pinned messages. Actual output %s", b.String())
+	}
+	room.SetModerator(user.Name, true)
+	user.handleInput("-pin #1", room)
+	if !strings.Contains(b.String(), "pinTester pinned message #1") {
+		t.Fatalf("expected the pin to be broadcast to the room. Actual output %s", b.String())
+	}
+	user.handleInput("-pins", room)
+	if !strings.Contains(b.String(), "Pinned messages:\n") || !strings.Contains(b.String(), "#1 tester]: Standup at 10") {
+		t.Fatalf("expected the pinned messages to be listed. Actual output %s", b.String())
+	}
+	user.handleInput("-unpin 1", room)
+	user.handleInput("-unpin 1", room)
+	if !strings.Contains(b.String(), "pinTester unpinned message #1") || !strings.Contains(b.String(), "Message #1 is not pinned.") {
+		t.Fatalf("expected the message to be unpinned once. Actual output %s", b.String())
+	}
+}
+
+func TestRoomPinsHandler(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	room := server.CreateRoomIfMissing("pinHttpRoom")
+	room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "pinHttpRoom", Sender: "tester", Value: "Read the rules"})
+	if err := room.Pin(1, "tester"); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}/pins", RoomPinsHandler)
+	req, err := http.NewRequest(http.MethodGet, "/rooms/pinHttpRoom/pins", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var pinnedMessages []pinnedMessage
+	if err = json.Unmarshal(rr.Body.Bytes(), &pinnedMessages); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || len(pinnedMessages) != 1 || pinnedMessages[0].ID != 1 || pinnedMessages[0].Message.Value != "Read the rules" {
+		t.Fatalf("expected the pinned message. Actual code %d and body %s", rr.Code, rr.Body.String())
+	}
+	req, err = http.NewRequest(http.MethodGet, "/rooms/missingRoom/pins", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected a missing room to not be found. Actual code %d", rr.Code)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pathUsers          = "/users"
+	pathUser           = "/users/{name}"
+	commandWho         = "-who"
+	commandAway        = "-away"
+	commandBack        = "-back"
+	statusActive       = "active"
+	statusIdle         = "idle"
+	statusAway         = "away"
+	statusOffline      = "offline"
+	defaultIdleTimeout = 10 * time.Minute
+)
+
+// idleTimeout is how long a user can go without sending a message or using a command before the user is idle.
+var idleTimeout = defaultIdleTimeout
+
+// presence is what is known about a user while the user is connected. It is shared by every copy of the user.
+type presence struct {
+	lock        sync.RWMutex
+	connected   time.Time
+	lastActive  time.Time
+	roomName    string
+	away        bool
+	awayReason  string
+	autoReplied map[string]bool
+}
+
+// userStatus is the information about a user returned by the users endpoints.
+type userStatus struct {
+	Name           string     `json:"name"`
+	Online         bool       `json:"online"`
+	Status         string     `json:"status"`
+	AwayReason     string     `json:"awayReason,omitempty"`
+	Room           string     `json:"room,omitempty"`
+	ConnectedSince *time.Time `json:"connectedSince,omitempty"`
+	IdleSeconds    int64      `json:"idleSeconds"`
+	LastSeen       time.Time  `json:"lastSeen"`
+}
+
+func newPresence() *presence {
+	now := time.Now()
+	return &presence{
+		lock:        sync.RWMutex{},
+		connected:   now,
+		lastActive:  now,
+		autoReplied: make(map[string]bool),
+	}
+}
+
+// markActive records that the user has just done something, such as sending a message or using a command.
+func (user *ChatUser) markActive() {
+	if user.presence == nil {
+		return
+	}
+	user.presence.lock.Lock()
+	user.presence.lastActive = time.Now()
+	user.presence.lock.Unlock()
+}
+
+// setRoom records the room the user is in. A blank name means the user is not in a room.
+func (user *ChatUser) setRoom(roomName string) {
+	if user.presence == nil {
+		return
+	}
+	user.presence.lock.Lock()
+	user.presence.roomName = roomName
+	user.presence.lock.Unlock()
+}
+
+// leaveRoom records the user is no longer in the room, unless the user has already entered another room.
+func (user *ChatUser) leaveRoom(roomName string) {
+	if user.presence == nil {
+		return
+	}
+	user.presence.lock.Lock()
+	if user.presence.roomName == roomName {
+		user.presence.roomName = ""
+	}
+	user.presence.lock.Unlock()
+}
+
+// setAway marks the user as away for the reason. Users that send a direct message to the user are told the reason once.
+func (user *ChatUser) setAway(reason string) {
+	if user.presence == nil {
+		return
+	}
+	user.presence.lock.Lock()
+	user.presence.away = true
+	user.presence.awayReason = reason
+	user.presence.autoReplied = make(map[string]bool)
+	user.presence.lock.Unlock()
+}
+
+// setBack marks the user as no longer away. Returns false if the user was not away.
+func (user *ChatUser) setBack() bool {
+	if user.presence == nil {
+		return false
+	}
+	user.presence.lock.Lock()
+	defer user.presence.lock.Unlock()
+	wasAway := user.presence.away
+	user.presence.away = false
+	user.presence.awayReason = ""
+	return wasAway
+}
+
+// autoReply retrieves the reason the user is away if the sender has not already been told the reason. If the user is not
+// away or the sender has already been told, false is returned.
+func (user *ChatUser) autoReply(senderName string) (string, bool) {
+	if user.presence == nil {
+		return "", false
+	}
+	user.presence.lock.Lock()
+	defer user.presence.lock.Unlock()
+	if !user.presence.away || user.presence.autoReplied[senderName] {
+		return "", false
+	}
+	user.presence.autoReplied[senderName] = true
+	return user.presence.awayReason, true
+}
+
+// status describes the user while the user is connected. Hidden rooms are not revealed.
+func (user *ChatUser) status() userStatus {
+	status := userStatus{
+		Name:   user.Name,
+		Online: true,
+		Status: statusActive,
+	}
+	if user.presence == nil {
+		return status
+	}
+	user.presence.lock.RLock()
+	connected := user.presence.connected
+	status.ConnectedSince = &connected
+	status.LastSeen = user.presence.lastActive
+	idle := time.Since(user.presence.lastActive)
+	status.IdleSeconds = int64(idle / time.Second)
+	if user.presence.away {
+		status.Status = statusAway
+		status.AwayReason = user.presence.awayReason
+	} else if idle >= idleTimeout {
+		status.Status = statusIdle
+	}
+	roomName := user.presence.roomName
+	user.presence.lock.RUnlock()
+	if room := server.FindRoom(roomName); room != nil && !room.IsHidden() {
+		status.Room = roomName
+	}
+	return status
+}
+
+// GetUserStatus retrieves the status of the user. Users that are not connected are described by when they were last seen.
+// If the user has never been seen, false is returned.
+func (server *ChatServer) GetUserStatus(userName string) (userStatus, bool) {
+	if user := server.GetUser(userName); user != nil {
+		return user.status(), true
+	}
+	profile := server.GetProfile(userName)
+	if profile.LastSeen.IsZero() {
+		return userStatus{}, false
+	}
+	return userStatus{Name: userName, Status: statusOffline, LastSeen: profile.LastSeen}, true
+}
+
+// ListUserStatuses retrieves the status of every user connected to the server, sorted by name.
+func (server *ChatServer) ListUserStatuses() []userStatus {
+	server.usersLock.RLock()
+	users := make([]*ChatUser, 0, len(server.users))
+	for _, user := range server.users {
+		users = append(users, user)
+	}
+	server.usersLock.RUnlock()
+	statuses := make([]userStatus, len(users))
+	for index, user := range users {
+		statuses[index] = user.status()
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// who lets the user know the status of the user in the message.
+func (user *ChatUser) who(msg string) {
+	parts := strings.Fields(msg)
+	if len(parts) < 2 {
+		user.ReceiveMessage("A user Name is required.")
+		return
+	}
+	status, ok := server.GetUserStatus(parts[1])
+	if !ok {
+		user.ReceiveMessage(fmt.Sprintf("%s has never been on the server.", parts[1]))
+		return
+	}
+	if !status.Online {
+		user.ReceiveMessage(fmt.Sprintf("%s is offline. Last seen on %s.", status.Name, status.LastSeen.Format(time.RFC1123)))
+		return
+	}
+	location := "not in a room"
+	if len(status.Room) != 0 {
+		location = "in the room " + status.Room
+	}
+	idle := time.Duration(status.IdleSeconds) * time.Second
+	user.ReceiveMessage(fmt.Sprintf("%s is online and %s. Connected since %s, idle for %s.", status.Name, location, status.ConnectedSince.Format(time.RFC1123), idle))
+	if status.Status == statusAway {
+		user.ReceiveMessage(fmt.Sprintf("%s is away: %s", status.Name, status.AwayReason))
+	}
+}
+
+// away marks the user as away for the reason in the message, or marks the user as back.
+func (user *ChatUser) away(command string, msg string) {
+	if command == commandBack {
+		if user.setBack() {
+			user.ReceiveMessage("You are no longer marked as away.")
+		} else {
+			user.ReceiveMessage("You were not marked as away.")
+		}
+		return
+	}
+	reason := statusAway
+	if parts := strings.SplitN(msg, " ", 2); len(parts) > 1 && len(strings.TrimSpace(parts[1])) != 0 {
+		reason = parts[1]
+	}
+	user.setAway(reason)
+	user.ReceiveMessage(fmt.Sprintf("You are marked as away: %s. Use %s when you return.", reason, commandBack))
+}
+
+// formatUsers lists the names of the users, one user per line, noting the users that are away or idle.
+func formatUsers(userNames []string) string {
+	lines := make([]string, len(userNames))
+	for index, userName := range userNames {
+		lines[index] = userName
+		user := server.GetUser(userName)
+		if user == nil {
+			continue
+		}
+		switch status := user.status(); status.Status {
+		case statusAway:
+			lines[index] = fmt.Sprintf("%s (away: %s)", userName, status.AwayReason)
+		case statusIdle:
+			lines[index] = fmt.Sprintf("%s (idle)", userName)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// UsersHandler handles listing the users connected to the server.
+func UsersHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	logger.Println("Received HTTP request to list users")
+	if !authorizeRead(writer, request) {
+		return
+	}
+	writeJSON(writer, http.StatusOK, server.ListUserStatuses())
+}
+
+// UserHandler handles retrieving the status of the user in the path.
+func UserHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	userName := mux.Vars(request)[pathVariableName]
+	logger.Println("Received HTTP request for the status of user " + userName)
+	if !authorizeRead(writer, request) {
+		return
+	}
+	status, ok := server.GetUserStatus(userName)
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		logger.Printf("ERROR: HTTP request for the status of user %s that has never been seen\n", userName)
+		writeHttpMessage(writer, `{"statusCode":"404", "reason":"The user has never been on the server"}`)
+		return
+	}
+	writeJSON(writer, http.StatusOK, status)
+}
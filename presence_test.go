@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChatServer_GetUserStatus(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var b bytes.Buffer
+	user := ChatUser{Name: "presenceTester", writer: &b}
+	server.AddUser(&user)
+	room := server.CreateRoomIfMissing("presenceRoom")
+	room.AddUser(user.Name)
+	status, ok := server.GetUserStatus(user.Name)
+	if !ok || !status.Online || status.Room != "presenceRoom" || status.ConnectedSince == nil {
+		t.Fatalf("expected the user to be online in the room. Actual status %+v", status)
+	}
+	room.SetModes(true, false)
+	if status, _ = server.GetUserStatus(user.Name); len(status.Room) != 0 {
+		t.Fatalf("expected a hidden room to not be revealed. Actual status %+v", status)
+	}
+	room.RemoveUser(user.Name)
+	server.RemoveUser(user.Name)
+	if status, ok = server.GetUserStatus(user.Name); !ok || status.Online || status.LastSeen.IsZero() {
+		t.Fatalf("expected the user to be offline with when they were last seen. Actual status %+v", status)
+	}
+	if _, ok = server.GetUserStatus("neverSeen"); ok {
+		t.Fatal("expected a user that has never been seen to not have a status")
+	}
+}
+
+func TestChatUser_handleInput_who(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var b bytes.Buffer
+	user := ChatUser{Name: "whoTester", writer: &b}
+	server.AddUser(&user)
+	room := server.CreateRoomIfMissing("whoRoom")
+	room.AddUser(user.Name)
+	user.handleInput("-who whoTester", room)
+	if !strings.Contains(b.String(), "whoTester is online and in the room whoRoom. Connected since") {
+		t.Fatalf("expected the status of the user to be shown. Actual output %s", b.String())
+	}
+}
+
+func TestUsersHandler(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var b bytes.Buffer
+	server.AddUser(&ChatUser{Name: "usersTester", writer: &b})
+	router := mux.NewRouter()
+	router.HandleFunc("/users", UsersHandler)
+	router.HandleFunc("/users/{name}", UserHandler)
+	req, err := http.NewRequest(http.MethodGet, "/users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var statuses []userStatus
+	if err = json.Unmarshal(rr.Body.Bytes(), &statuses); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || len(statuses) != 1 || statuses[0].Name != "usersTester" || !statuses[0].Online {
+		t.Fatalf("expected the online user to be listed. Status %v, body %s", rr.Code, rr.Body.String())
+	}
+	req, err = http.NewRequest(http.MethodGet, "/users/neverSeen", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestChatUser_handleInput_awayAndBack(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var awayOutput, senderOutput bytes.Buffer
+	awayUser := ChatUser{Name: "awayTester", writer: &awayOutput, blockedUsers: make(map[string]bool)}
+	sender := ChatUser{Name: "awaySender", writer: &senderOutput, blockedUsers: make(map[string]bool)}
+	server.AddUser(&awayUser)
+	server.AddUser(&sender)
+	room := server.CreateRoomIfMissing("awayRoom")
+	room.AddUser(awayUser.Name)
+	room.AddUser(sender.Name)
+	awayUser.handleInput("-away At lunch", room)
+	if status, _ := server.GetUserStatus(awayUser.Name); status.Status != statusAway || status.AwayReason != "At lunch" {
+		t.Fatalf("expected the user to be away. Actual status %+v", status)
+	}
+	sender.handleInput("-lu", room)
+	if !strings.Contains(senderOutput.String(), "awayTester (away: At lunch)") {
+		t.Fatalf("expected the away user to be noted in the list of users. Actual output %s", senderOutput.String())
+	}
+	//
+	// The sender is only told the reason once
+	//
+	sender.handleInput("-m awayTester Are you there?", room)
+	sender.handleInput("-m awayTester Hello?", room)
+	if strings.Count(senderOutput.String(), "[auto-reply] I am away: At lunch") != 1 {
+		t.Fatalf("expected the sender to receive one auto-reply. Actual output %s", senderOutput.String())
+	}
+	awayUser.handleInput("-back", room)
+	if status, _ := server.GetUserStatus(awayUser.Name); status.Status != statusActive {
+		t.Fatalf("expected the user to be back. Actual status %+v", status)
+	}
+}
+
+func TestChatUser_status_idle(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+		idleTimeout = defaultIdleTimeout
+	}()
+	idleTimeout = 0
+	var b bytes.Buffer
+	user := ChatUser{Name: "idleTester", writer: &b}
+	server.AddUser(&user)
+	if status, _ := server.GetUserStatus(user.Name); status.Status != statusIdle {
+		t.Fatalf("expected the user to be idle. Actual status %+v", status)
+	}
+}
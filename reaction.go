@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	commandReact            = "-react"
+	commandUnreact          = "-unreact"
+	pathRoomMessageReaction = "/rooms/{name}/messages/{id}/reactions/{reaction}"
+	pathVariableReaction    = "reaction"
+	maxReactionLength       = 32
+)
+
+var errInvalidReaction = errors.New("reaction must be a single emoji or word")
+
+// React adds the reaction of the user to the message with the ID, or removes it. Each user can react to a message with a
+// reaction once. Deleted messages cannot be reacted to.
+func (room *ChatRoom) React(id int64, userName string, reaction string, remove bool) (message.ChatMessage, error) {
+	if !isValidReaction(reaction) {
+		return message.ChatMessage{}, errors.Wrapf(errInvalidReaction, "failed to react to message %d of room %s", id, room.Name)
+	}
+	return room.amendMessage(message.Amendment{ID: id, Time: time.Now(), Reaction: reaction, User: userName, Removed: remove}, userName)
+}
+
+// isValidReaction checks the reaction is a short value without whitespace, such as an emoji or ':+1:'.
+func isValidReaction(reaction string) bool {
+	if len(reaction) == 0 || utf8.RuneCountInString(reaction) > maxReactionLength {
+		return false
+	}
+	return strings.IndexFunc(reaction, unicode.IsSpace) == -1
+}
+
+// describeReaction describes the change to the reactions of the message for the users in the room.
+func describeReaction(amendment message.Amendment, chatMessage message.ChatMessage) string {
+	change := fmt.Sprintf("%s reacted with %s to #%d", amendment.User, amendment.Reaction, chatMessage.ID)
+	if amendment.Removed {
+		change = fmt.Sprintf("%s removed their %s reaction to #%d", amendment.User, amendment.Reaction, chatMessage.ID)
+	}
+	if len(chatMessage.Reactions) == 0 {
+		return change
+	}
+	return fmt.Sprintf("%s [%s]", change, chatMessage.ReactionSummary())
+}
+
+// react adds or removes the reaction in the message to the message with the ID in the message.
+func (user *ChatUser) react(command string, msg string, room *ChatRoom) {
+	parts := strings.Fields(msg)
+	if len(parts) < 3 {
+		user.ReceiveMessage("A message ID and a reaction are required.")
+		return
+	}
+	id, err := parseMessageID(parts[1])
+	if err != nil {
+		user.ReceiveMessage(fmt.Sprintf("%s is not a message ID.", parts[1]))
+		return
+	}
+	if !user.isInRoom(room) {
+		return
+	}
+	chatMessage, err := room.React(id, user.Name, parts[2], command == commandUnreact)
+	if errors.Cause(err) == errInvalidReaction {
+		user.ReceiveMessage(fmt.Sprintf("A reaction can be at most %d characters without spaces.", maxReactionLength))
+	} else if err != nil {
+		user.receiveMessageError(id, err)
+	} else if len(chatMessage.Reactions) == 0 {
+		user.ReceiveMessage(fmt.Sprintf("Message #%d has no reactions.", id))
+	} else {
+		user.ReceiveMessage(fmt.Sprintf("Reactions to #%d: %s", id, chatMessage.ReactionSummary()))
+	}
+}
+
+// RoomMessageReactionHandler handles adding the reaction in the path to the message in the path on behalf of the sender, and
+// removing it.
+func RoomMessageReactionHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	variables := mux.Vars(request)
+	roomName := variables[pathVariableName]
+	reaction := variables[pathVariableReaction]
+	logger.Printf("Received HTTP request to react with %s to message %s of room %s\n", reaction, variables[pathVariableID], roomName)
+	id, ok := parseMessageIDVariable(writer, variables)
+	if !ok {
+		return
+	}
+	senderName, ok := getSenderName(writer, request)
+	if !ok {
+		return
+	}
+	if server.FindRoom(roomName) == nil {
+		writeRoomNotFound(writer, roomName)
+		return
+	}
+	room, err := server.OpenRoom(roomName, senderName, hostOf(request.RemoteAddr), request.Header.Get(headerRoomPassword))
+	if err != nil {
+		writeRoomForbidden(writer, senderName, roomName, err)
+		return
+	}
+	chatMessage, err := room.React(id, senderName, reaction, request.Method == http.MethodDelete)
+	if errors.Cause(err) == errInvalidReaction {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Printf("ERROR: HTTP request has an invalid reaction %s\n", reaction)
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"The reaction must be at most 32 characters without spaces"}`)
+		return
+	} else if err != nil {
+		writeMessageError(writer, roomName, id, err)
+		return
+	}
+	writeJSON(writer, http.StatusOK, chatMessage)
+}
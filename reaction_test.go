@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatRoom_React(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var senderOutput bytes.Buffer
+	server.AddUser(&ChatUser{Name: "reactSender", writer: &senderOutput})
+	room := server.CreateRoomIfMissing("reactRoom")
+	room.AddUser("reactSender")
+	room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "reactRoom", Sender: "reactSender", Value: "Lunch?"})
+	if _, err := room.React(1, "tester", ":+1:", false); err != nil {
+		t.Fatal(err)
+	}
+	chatMessage, err := room.React(1, "tester1", ":+1:", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chatMessage.Reactions) != 1 || chatMessage.Reactions[0].Count != 2 {
+		t.Fatalf("expected the reactions to be counted. Actual reactions %+v", chatMessage.Reactions)
+	}
+	if !strings.Contains(senderOutput.String(), "tester1 reacted with :+1: to #1 [:+1: 2]") {
+		t.Fatalf("expected the reaction to be sent to the room. Actual output %s", senderOutput.String())
+	}
+	if _, err = room.React(1, "tester", "thumbs up", false); errors.Cause(err) != errInvalidReaction {
+		t.Fatalf("expected a reaction with spaces to be rejected. Actual error %+v", err)
+	}
+	if _, err = room.React(1, "tester", ":+1:", true); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := room.GetMessages(message.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].ReactionSummary() != ":+1: 1" {
+		t.Fatalf("expected the reactions to be kept with the history. Actual messages %+v", messages)
+	}
+}
+
+func TestChatUser_handleInput_react(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var b bytes.Buffer
+	user := ChatUser{Name: "reactTester", writer: &b}
+	server.AddUser(&user)
+	room := server.CreateRoomIfMissing("reactCommandRoom")
+	room.AddUser(user.Name)
+	room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "reactCommandRoom", Sender: "tester", Value: "Lunch?"})
+	user.handleInput("-react #1 :tada:", room)
+	if !strings.Contains(b.String(), "Reactions to #1: :tada: 1") {
+		t.Fatalf("expected the reactions to be shown. Actual output %s", b.String())
+	}
+	user.handleInput("-unreact 1 :tada:", room)
+	if !strings.Contains(b.String(), "Message #1 has no reactions.") {
+		t.Fatalf("expected the reaction to be removed. Actual output %s", b.String())
+	}
+	room.RemoveUser(user.Name)
+	user.handleInput("-react 1 :tada:", room)
+	if chatMessage, _ := room.GetMessage(1); len(chatMessage.Reactions) != 0 {
+		t.Fatalf("expected a user that left the room to not react. Actual reactions %+v", chatMessage.Reactions)
+	}
+}
+
+func TestRoomMessageReactionHandler(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	server.AllowSenderName(true)
+	defer func() {
+		server = CreateServer()
+	}()
+	room := server.CreateRoomIfMissing("reactHttpRoom")
+	room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "reactHttpRoom", Sender: "tester", Value: "Lunch?"})
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}/messages/{id}/reactions/{reaction}", RoomMessageReactionHandler)
+	req, err := http.NewRequest(http.MethodPut, "/rooms/reactHttpRoom/messages/1/reactions/:+1:", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(headerSenderName, "tester1")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var chatMessage message.ChatMessage
+	if err = json.Unmarshal(rr.Body.Bytes(), &chatMessage); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || len(chatMessage.Reactions) != 1 || chatMessage.Reactions[0].Users[0] != "tester1" {
+		t.Fatalf("expected the reaction to be added. Actual code %d and body %s", rr.Code, rr.Body.String())
+	}
+	req, err = http.NewRequest(http.MethodPut, "/rooms/reactHttpRoom/messages/2/reactions/:+1:", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(headerSenderName, "tester1")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected a reaction to a missing message to not be found. Actual code %d", rr.Code)
+	}
+}
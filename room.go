@@ -3,26 +3,67 @@ package main
 import (
 	"fmt"
 	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
 	"sync"
+	"time"
 )
 
 // ChatRoom that represents a possible room for users to chat within.
 type ChatRoom struct {
 	Name           string
+	Created        time.Time
+	Owner          string
+	moderationLock sync.RWMutex
+	moderators     map[string]bool
+	bans           []Ban
+	hidden         bool
+	inviteOnly     bool
+	passwordHash   []byte
+	invited        map[string]bool
+	topicLock      sync.RWMutex
+	topics         []Topic
+	pinLock        sync.RWMutex
+	pins           []Pin
+	activityLock   sync.RWMutex
+	lastActive     time.Time
 	userLock       sync.RWMutex
 	users          []string
 	messageChannel chan message.ChatMessage
 	messageLock    sync.RWMutex
-	messages       []message.ChatMessage
+	closed         bool
+	stopped        chan struct{}
+	stopOnce       sync.Once
+	store          message.Store
+	subscribeLock  sync.RWMutex
+	subscribers    map[chan clientEvent]bool
 }
 
-// CreateRoom creates a room with the provided name.
+// CreateRoom creates a room with the provided name. The room's history is kept in memory.
 func CreateRoom(name string) ChatRoom {
+	return CreateRoomWithStore(name, message.NewMemoryStore())
+}
+
+// CreateRoomWithStore creates a room with the provided name that keeps its history in the provided store.
+func CreateRoomWithStore(name string, store message.Store) ChatRoom {
+	now := time.Now()
 	return ChatRoom{
 		Name:           name,
+		Created:        now,
+		moderationLock: sync.RWMutex{},
+		moderators:     make(map[string]bool),
+		invited:        make(map[string]bool),
+		topicLock:      sync.RWMutex{},
+		pinLock:        sync.RWMutex{},
+		activityLock:   sync.RWMutex{},
+		lastActive:     now,
 		userLock:       sync.RWMutex{},
 		messageChannel: make(chan message.ChatMessage, 100),
 		messageLock:    sync.RWMutex{},
+		stopped:        make(chan struct{}),
+		stopOnce:       sync.Once{},
+		store:          store,
+		subscribeLock:  sync.RWMutex{},
+		subscribers:    make(map[chan clientEvent]bool),
 	}
 }
 
@@ -42,6 +83,10 @@ func (room *ChatRoom) AddUser(userName string) {
 	room.userLock.Lock()
 	room.users = append(room.users, userName)
 	room.userLock.Unlock()
+	room.markActive()
+	if user := server.GetUser(userName); user != nil {
+		user.setRoom(room.Name)
+	}
 	logger.Printf("%s entered the room %s\n", userName, room.Name)
 	//
 	// Notify others that a new user has joined
@@ -59,11 +104,21 @@ func (room *ChatRoom) RemoveUser(userName string) {
 		}
 	}
 	room.userLock.Unlock()
+	if user := server.GetUser(userName); user != nil {
+		user.leaveRoom(room.Name)
+	}
 	logger.Printf("%s left the room %s\n", userName, room.Name)
 }
 
-// SendMessage sends the message to the room message channel. Allows for message to be async sent to all users.
+// SendMessage sends the message to the room message channel. Allows for message to be async sent to all users. If the
+// room has been closed, the message is dropped.
 func (room *ChatRoom) SendMessage(message message.ChatMessage) {
+	room.messageLock.RLock()
+	defer room.messageLock.RUnlock()
+	if room.closed {
+		logger.Printf("WARN: room %s is closed. Dropping message from %s\n", room.Name, message.Sender)
+		return
+	}
 	room.messageChannel <- message
 }
 
@@ -72,22 +127,76 @@ func (room *ChatRoom) HandleMessages() {
 	for chatMessage := range room.messageChannel {
 		room.sendUserMessage(chatMessage)
 	}
+	//
+	// No more messages will be handled, so let subscribers know the room has closed
+	//
+	room.subscribeLock.Lock()
+	for subscriber := range room.subscribers {
+		close(subscriber)
+	}
+	room.subscribers = make(map[chan clientEvent]bool)
+	room.subscribeLock.Unlock()
+	room.stopOnce.Do(func() {
+		close(room.stopped)
+	})
+}
+
+// Subscribe registers to receive an event for every message handled by the room and every change to a message, such as an
+// edit. The returned channel is closed when the room is closed. The returned function must be called to stop receiving
+// events.
+func (room *ChatRoom) Subscribe() (<-chan clientEvent, func()) {
+	subscriber := make(chan clientEvent, 100)
+	room.subscribeLock.Lock()
+	room.subscribers[subscriber] = true
+	room.subscribeLock.Unlock()
+	unsubscribe := func() {
+		room.subscribeLock.Lock()
+		if room.subscribers[subscriber] {
+			delete(room.subscribers, subscriber)
+			close(subscriber)
+		}
+		room.subscribeLock.Unlock()
+	}
+	return subscriber, unsubscribe
+}
+
+func (room *ChatRoom) publish(event clientEvent) {
+	room.subscribeLock.RLock()
+	defer room.subscribeLock.RUnlock()
+	for subscriber := range room.subscribers {
+		//
+		// Do not let a slow subscriber hold up the room
+		//
+		select {
+		case subscriber <- event:
+		default:
+			logger.Printf("WARN: subscriber of room %s is not keeping up. Dropping message\n", room.Name)
+		}
+	}
 }
 
 func (room *ChatRoom) sendUserMessage(message message.ChatMessage) {
 	//
 	// Add message to room history
 	//
-	room.messageLock.Lock()
-	room.messages = append(room.messages, message)
-	room.messageLock.Unlock()
+	if stored, err := room.store.Append(message); err != nil {
+		logger.Printf("ERROR: failed to add message to the history of room %s: %+v\n", room.Name, err)
+	} else {
+		message = stored
+	}
+	room.markActive()
 	//
 	// Format the logs with the chatRoom and ChatUser
 	//
 	logger.Println(message.LogMessage())
 	//
-	// Send message to all users in room
+	// Let subscribers, such as event streams, know of the message
 	//
+	room.publish(clientEvent{Type: eventMessage, Room: room.Name, Message: &message})
+	//
+	// Send message to all users in room. Mentioned users get the message highlighted
+	//
+	mentions := message.Mentions()
 	for _, name := range room.users {
 		if message.Sender == name {
 			continue
@@ -99,9 +208,30 @@ func (room *ChatRoom) sendUserMessage(message message.ChatMessage) {
 			//
 			// Format the final message with the ChatUser and timestamp
 			//
-			otherUser.ReceiveMessage(message.RoomMessage())
+			if containsName(mentions, name) {
+				otherUser.ReceiveMention(message)
+			} else {
+				otherUser.ReceiveChatMessage(message)
+			}
 		}
 	}
+	//
+	// Let mentioned users outside the room know they were mentioned
+	//
+	room.notifyMentions(message, mentions)
+}
+
+// GetLastActive retrieves when a message was last sent to the room or a user last entered the room.
+func (room *ChatRoom) GetLastActive() time.Time {
+	room.activityLock.RLock()
+	defer room.activityLock.RUnlock()
+	return room.lastActive
+}
+
+func (room *ChatRoom) markActive() {
+	room.activityLock.Lock()
+	room.lastActive = time.Now()
+	room.activityLock.Unlock()
 }
 
 // Broadcast sends a message to all users in the room, regardless of the user that caused the message to occur.
@@ -110,50 +240,57 @@ func (room *ChatRoom) Broadcast(message string) {
 	users := room.users
 	room.userLock.RUnlock()
 	for _, name := range users {
-		server.GetUser(name).ReceiveMessage(message)
+		server.GetUser(name).ReceiveBroadcast(room.Name, message)
 	}
 }
 
-// Close closes the message channel.
+// Close closes the message channel. Messages already in the channel are still handled.
 func (room *ChatRoom) Close() {
-	close(room.messageChannel)
+	room.messageLock.Lock()
+	defer room.messageLock.Unlock()
+	if !room.closed {
+		room.closed = true
+		close(room.messageChannel)
+	}
 }
 
-// GetMessages retrieves messages based on the provided query.
-func (room *ChatRoom) GetMessages(query message.Query) []message.ChatMessage {
-	var matchingMessages []message.ChatMessage
-	room.messageLock.RLock()
-	messages := room.messages
-	room.messageLock.RUnlock()
-	for _, chatMessage := range messages {
-		messageMatches := true
-		//
-		// If the sender name matches the message sender name, query matches
-		//
-		if len(query.SenderName) != 0 && query.SenderName != chatMessage.Sender {
-			messageMatches = false
-		}
-		//
-		// If message falls after the start date, it matches
-		//
-		if messageMatches && !query.Start.IsZero() && !chatMessage.Timestamp.After(query.Start) {
-			messageMatches = false
-		}
-		//
-		// If message falls before the end date, then it matches
-		//
-		if messageMatches && !query.End.IsZero() && !chatMessage.Timestamp.Before(query.End) {
-			messageMatches = false
-		}
-		//
-		// If message matches all criteria, add to slice
-		//
-		if messageMatches {
-			matchingMessages = append(matchingMessages, chatMessage)
-		}
+// GetMessages retrieves messages from the room's history based on the provided query.
+func (room *ChatRoom) GetMessages(query message.Query) ([]message.ChatMessage, error) {
+	query.RoomName = room.Name
+	messages, err := room.store.Query(query)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query the history of room %s", room.Name)
 	}
-	if len(matchingMessages) == 0 {
-		matchingMessages = make([]message.ChatMessage, 0)
+	return messages, nil
+}
+
+// WaitForMessages retrieves messages based on the provided query. If no messages match, it waits until a matching message
+// is sent to the room, the timeout elapses, or done is closed.
+func (room *ChatRoom) WaitForMessages(query message.Query, timeout time.Duration, done <-chan struct{}) ([]message.ChatMessage, error) {
+	//
+	// Subscribe before querying so a message sent in between is not missed
+	//
+	events, unsubscribe := room.Subscribe()
+	defer unsubscribe()
+	matchingMessages, err := room.GetMessages(query)
+	if err != nil || len(matchingMessages) != 0 {
+		return matchingMessages, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case event, open := <-events:
+			if !open {
+				return matchingMessages, nil
+			}
+			if event.Type == eventMessage && query.Matches(*event.Message) {
+				return room.GetMessages(query)
+			}
+		case <-timer.C:
+			return matchingMessages, nil
+		case <-done:
+			return matchingMessages, nil
+		}
 	}
-	return matchingMessages
 }
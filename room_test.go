@@ -115,7 +115,7 @@ func TestChatRoom_HandleMessages_sameUser(t *testing.T) {
 	})
 	room.Close()
 	room.HandleMessages()
-	if len(room.messages) != 1 {
+	if messages, _ := room.GetMessages(message.Query{}); len(messages) != 1 {
 		t.Fatal("message was not added to the room's messages")
 	}
 }
@@ -153,7 +153,7 @@ func TestChatRoom_HandleMessages_differentUser(t *testing.T) {
 	})
 	room.Close()
 	room.HandleMessages()
-	if len(room.messages) != 1 {
+	if messages, _ := room.GetMessages(message.Query{}); len(messages) != 1 {
 		t.Fatal("message was not added to the room's messages")
 	}
 }
@@ -203,7 +203,11 @@ func TestChatRoom_GetMessages_all(t *testing.T) {
 	})
 	room.Close()
 	room.HandleMessages()
-	if len(room.GetMessages(message.Query{})) == 0 {
+	messages, err := room.GetMessages(message.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) == 0 {
 		t.Fatal("failed to query any messages")
 	}
 }
@@ -233,7 +237,11 @@ func TestChatRoom_GetMessages_notBySender(t *testing.T) {
 	})
 	room.Close()
 	room.HandleMessages()
-	if len(room.GetMessages(message.Query{SenderName: "tester1"})) != 0 {
+	messages, err := room.GetMessages(message.Query{SenderName: "tester1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 0 {
 		t.Fatal("expected to not find any messages")
 	}
 }
@@ -264,7 +272,11 @@ func TestChatRoom_GetMessages_NotBeforeEnd(t *testing.T) {
 	})
 	room.Close()
 	room.HandleMessages()
-	if len(room.GetMessages(message.Query{End: start})) != 0 {
+	messages, err := room.GetMessages(message.Query{End: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 0 {
 		t.Fatal("expected to not find any messages")
 	}
 }
@@ -294,7 +306,39 @@ func TestChatRoom_GetMessages_notAfterStart(t *testing.T) {
 	})
 	room.Close()
 	room.HandleMessages()
-	if len(room.GetMessages(message.Query{Start: time.Now().Add(5 * time.Second)})) != 0 {
+	messages, err := room.GetMessages(message.Query{Start: time.Now().Add(5 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 0 {
 		t.Fatal("expected no messages to match")
 	}
 }
+
+func TestChatRoom_Subscribe(t *testing.T) {
+	server = CreateServer()
+	//
+	// reset
+	//
+	defer func() {
+		server = CreateServer()
+	}()
+	room := CreateRoom("testRoom")
+	messages, unsubscribe := room.Subscribe()
+	defer unsubscribe()
+	room.SendMessage(message.ChatMessage{
+		Timestamp: time.Now(),
+		Room:      "testRoom",
+		Sender:    "tester",
+		Value:     "Hello from a test",
+	})
+	room.Close()
+	room.HandleMessages()
+	event := <-messages
+	if event.Type != eventMessage || event.Message.Value != "Hello from a test" {
+		t.Fatal("subscriber did not receive the message")
+	}
+	if _, open := <-messages; open {
+		t.Fatal("subscriber channel was not closed when the room closed")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	pathRooms     = "/rooms"
+	pathRoomUsers = "/rooms/{name}/users"
+)
+
+// roomSummary is the information about a room returned when listing rooms.
+type roomSummary struct {
+	Name       string    `json:"name"`
+	Created    time.Time `json:"created"`
+	Users      int       `json:"users"`
+	LastActive time.Time `json:"lastActive"`
+	Topic      *Topic    `json:"topic,omitempty"`
+}
+
+// roomSettings is the body of a HTTP request to create a room.
+type roomSettings struct {
+	Topic      string `json:"topic"`
+	Hidden     bool   `json:"hidden"`
+	InviteOnly bool   `json:"inviteOnly"`
+	Password   string `json:"password"`
+}
+
+func summarizeRoom(room *ChatRoom) roomSummary {
+	summary := roomSummary{
+		Name:       room.Name,
+		Created:    room.Created,
+		Users:      len(room.GetUsers()),
+		LastActive: room.GetLastActive(),
+	}
+	if topic, ok := room.GetTopic(); ok {
+		summary.Topic = &topic
+	}
+	return summary
+}
+
+// RoomsHandler handles listing the rooms on the server. Hidden rooms are not listed.
+func RoomsHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	logger.Println("Received HTTP request to list rooms")
+	if !authorizeRead(writer, request) {
+		return
+	}
+	roomNames := server.ListRooms()
+	sort.Strings(roomNames)
+	rooms := make([]roomSummary, 0, len(roomNames))
+	for _, roomName := range roomNames {
+		if room := server.FindRoom(roomName); room != nil {
+			rooms = append(rooms, summarizeRoom(room))
+		}
+	}
+	writeJSON(writer, http.StatusOK, rooms)
+}
+
+// RoomHandler handles creating the room in the path with the settings in the body, with the sender as the owner of the
+// room, and removing the room. Only admins can remove rooms.
+func RoomHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	roomName := mux.Vars(request)[pathVariableName]
+	if request.Method == http.MethodDelete {
+		removeRoom(writer, request, roomName)
+	} else {
+		createRoom(writer, request, roomName)
+	}
+}
+
+func createRoom(writer http.ResponseWriter, request *http.Request, roomName string) {
+	logger.Println("Received HTTP request to create room " + roomName)
+	senderName, ok := getSenderName(writer, request)
+	if !ok {
+		return
+	}
+	//
+	// The settings are optional, so an empty body creates a public room
+	//
+	var settings roomSettings
+	if err := json.NewDecoder(request.Body).Decode(&settings); err != nil && err != io.EOF {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Printf("ERROR: HTTP request to create room %s has an invalid body: %+v\n", roomName, err)
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"Body must be the settings of the room"}`)
+		return
+	}
+	if server.FindRoom(roomName) != nil {
+		writer.WriteHeader(http.StatusConflict)
+		logger.Printf("ERROR: HTTP request to create room %s that already exists\n", roomName)
+		writeHttpMessage(writer, `{"statusCode":"409", "reason":"The room already exists"}`)
+		return
+	}
+	//
+	// Only the owner can manage a restricted room, so a room without an owner must be open to everyone
+	//
+	owner := server.roomOwner(senderName)
+	if len(owner) == 0 && (settings.Hidden || settings.InviteOnly || len(settings.Password) != 0) {
+		writer.WriteHeader(http.StatusForbidden)
+		logger.Printf("ERROR: unregistered user %s attempted to create restricted room %s\n", senderName, roomName)
+		writeHttpMessage(writer, `{"statusCode":"403", "reason":"Only registered users can create hidden, invite only or password protected rooms"}`)
+		return
+	}
+	passwordHash, err := hashRoomPassword(roomName, settings.Password)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		logger.Printf("ERROR: failed to set the password of room %s: %+v\n", roomName, err)
+		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to set the password of the room"}`)
+		return
+	}
+	//
+	// Apply the settings before the room is added to the server, so no one can enter the room without them
+	//
+	room, created := server.createRoom(roomName, owner, func(room *ChatRoom) {
+		room.hidden = settings.Hidden
+		room.inviteOnly = settings.InviteOnly
+		room.passwordHash = passwordHash
+		if len(settings.Topic) != 0 {
+			room.topics = []Topic{{Value: settings.Topic, SetBy: senderName, Set: time.Now()}}
+		}
+	})
+	if !created {
+		writer.WriteHeader(http.StatusConflict)
+		logger.Printf("ERROR: room %s was created by another user first\n", roomName)
+		writeHttpMessage(writer, `{"statusCode":"409", "reason":"The room already exists"}`)
+		return
+	}
+	logger.Printf("Created room %s over HTTP for %s\n", roomName, senderName)
+	writeJSON(writer, http.StatusCreated, summarizeRoom(room))
+}
+
+func removeRoom(writer http.ResponseWriter, request *http.Request, roomName string) {
+	logger.Println("Received HTTP request to remove room " + roomName)
+	if !authorizeAdmin(writer, request) {
+		return
+	}
+	if roomName == defaultRoom {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Println("ERROR: HTTP request to remove the default room")
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"The default room cannot be removed"}`)
+		return
+	}
+	room := server.FindRoom(roomName)
+	if room == nil {
+		writer.WriteHeader(http.StatusNotFound)
+		logger.Printf("ERROR: HTTP request to remove room %s that does not exist\n", roomName)
+		writeHttpMessage(writer, `{"statusCode":"404", "reason":"The room does not exist"}`)
+		return
+	}
+	//
+	// Let the users in the room know they need to enter another room
+	//
+	for _, userName := range room.GetUsers() {
+		room.RemoveUser(userName)
+		if user := server.GetUser(userName); user != nil {
+			user.ReceiveMessage(fmt.Sprintf("The room %s has been removed by an admin. Use %s to enter another room.", roomName, commandChangeRoom))
+		}
+	}
+	server.RemoveRoom(roomName)
+	writer.WriteHeader(http.StatusOK)
+	writeHttpMessage(writer, `{"statusCode":"200", "reason":"Room successfully removed."}`)
+}
+
+// RoomUsersHandler handles listing the users in the room in the path. Invite only and password protected rooms require
+// the same access as entering the room.
+func RoomUsersHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	roomName := mux.Vars(request)[pathVariableName]
+	logger.Println("Received HTTP request to list the users in room " + roomName)
+	if !authorizeRead(writer, request) {
+		return
+	}
+	room := server.FindRoom(roomName)
+	if room == nil {
+		writer.WriteHeader(http.StatusNotFound)
+		logger.Printf("ERROR: HTTP request for the users of room %s that does not exist\n", roomName)
+		writeHttpMessage(writer, `{"statusCode":"404", "reason":"The room does not exist"}`)
+		return
+	}
+	if !authorizeRoom(writer, request, room) {
+		return
+	}
+	users := append([]string{}, room.GetUsers()...)
+	sort.Strings(users)
+	writeJSON(writer, http.StatusOK, users)
+}
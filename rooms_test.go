@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoomsHandler(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	server.CreateRoomIfMissing("testRoom").SetTopic("Testing topics", "tester")
+	server.CreateRoomIfMissing("hiddenRoom").SetModes(true, false)
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms", RoomsHandler)
+	req, err := http.NewRequest(http.MethodGet, "/rooms", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var rooms []roomSummary
+	if err = json.Unmarshal(rr.Body.Bytes(), &rooms); err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].Name != "testRoom" || rooms[0].Topic == nil || rooms[0].Topic.Value != "Testing topics" {
+		t.Fatalf("expected only the room that is not hidden to be listed with its topic. Actual rooms %+v", rooms)
+	}
+}
+
+func TestRoomHandler_CreateListUsersAndRemove(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	for _, accountName := range []string{"admin", "tester"} {
+		if err := server.RegisterAccount(accountName, "password1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.SetAdmins([]string{"admin"})
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}", RoomHandler).Methods(http.MethodPut, http.MethodDelete)
+	router.HandleFunc("/rooms/{name}/users", RoomUsersHandler)
+	//
+	// Create the room, then attempt to create it again
+	//
+	for _, expectedStatus := range []int{http.StatusCreated, http.StatusConflict} {
+		req, err := http.NewRequest(http.MethodPut, "/rooms/restRoom", bytes.NewBufferString(`{"topic":"Created over HTTP","hidden":true}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("tester", "password1")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if status := rr.Code; status != expectedStatus {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, expectedStatus)
+		}
+	}
+	room := server.FindRoom("restRoom")
+	if room == nil || room.Owner != "tester" {
+		t.Fatal("expected the room to be created with the sender as the owner")
+	}
+	if topic, _ := room.GetTopic(); topic.Value != "Created over HTTP" {
+		t.Fatalf("expected the room to be created with the topic. Actual topic %+v", topic)
+	}
+	if !room.IsHidden() {
+		t.Fatal("expected the room to be created hidden")
+	}
+	//
+	// List the users in the room
+	//
+	var b bytes.Buffer
+	server.AddUser(&ChatUser{Name: "restUser", writer: &b})
+	room.AddUser("restUser")
+	req, err := http.NewRequest(http.MethodGet, "/rooms/restRoom/users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "[\n  \"restUser\"\n]" {
+		t.Errorf("handler did not list the users in the room. Status %v, body %s", rr.Code, rr.Body.String())
+	}
+	//
+	// Only admins can remove the room
+	//
+	req, err = http.NewRequest(http.MethodDelete, "/rooms/restRoom", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+	req, err = http.NewRequest(http.MethodDelete, "/rooms/restRoom", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("admin", "password1")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if server.FindRoom("restRoom") != nil {
+		t.Fatal("expected the room to be removed")
+	}
+	if !strings.Contains(b.String(), "The room restRoom has been removed by an admin.") {
+		t.Fatalf("expected the user in the room to be told the room was removed. Actual output %s", b.String())
+	}
+}
+
+func TestRoomHandler_CreateRestrictedUnregistered(t *testing.T) {
+	server = CreateServer()
+	server.AllowSenderName(true)
+	defer func() {
+		server = CreateServer()
+	}()
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}", RoomHandler).Methods(http.MethodPut)
+	//
+	// A room without an owner cannot be restricted, as no one could manage it
+	//
+	for _, body := range []string{`{"hidden":true}`, `{"inviteOnly":true}`, `{"password":"secret"}`} {
+		req, err := http.NewRequest(http.MethodPut, "/rooms/restRoom", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(headerSenderName, "unregistered")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusForbidden {
+			t.Errorf("handler returned wrong status code for %s: got %v want %v", body, status, http.StatusForbidden)
+		}
+	}
+	if server.FindRoom("restRoom") != nil {
+		t.Fatal("expected the restricted room not to be created")
+	}
+	req, err := http.NewRequest(http.MethodPut, "/rooms/restRoom", bytes.NewBufferString(`{"topic":"Open to everyone"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(headerSenderName, "unregistered")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	commandSearch      = "-search"
+	pathSearch         = "/search"
+	parameterQuery     = "q"
+	parameterRoom      = "room"
+	parameterOffset    = "offset"
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// searchResponse is a page of the messages matching a search, best match first.
+type searchResponse struct {
+	Query      string                 `json:"query"`
+	Total      int                    `json:"total"`
+	Offset     int                    `json:"offset"`
+	NextOffset int                    `json:"nextOffset,omitempty"`
+	Results    []message.SearchResult `json:"results"`
+}
+
+// Search retrieves the messages of the rooms matching the text, best match first. Words in double quotes must appear next to
+// each other. The page of results starts at the offset and contains at most limit results. The total number of matching
+// messages is also returned.
+func (server *ChatServer) Search(text string, rooms []*ChatRoom, offset int, limit int) ([]message.SearchResult, int, error) {
+	histories := make([]string, len(rooms))
+	for index, room := range rooms {
+		histories[index] = room.Name
+	}
+	return server.store.Search(message.SearchQuery{Text: text, Histories: histories, Offset: offset, Limit: limit})
+}
+
+// searchableRooms retrieves the rooms the user can search, which are the listed rooms the user can enter without a password.
+func (server *ChatServer) searchableRooms(userName string, address string) []*ChatRoom {
+	var rooms []*ChatRoom
+	for _, roomName := range server.ListRooms() {
+		room := server.FindRoom(roomName)
+		if room == nil || room.IsBanned(userName, address) {
+			continue
+		} else if room.IsRestricted() && room.CheckAccess(userName, "") != nil {
+			continue
+		}
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name < rooms[j].Name
+	})
+	return rooms
+}
+
+// search lets the user know of the messages matching the text in the message, from the current room and every room the user
+// can enter.
+func (user *ChatUser) search(msg string, room *ChatRoom) {
+	text := strings.TrimSpace(strings.TrimPrefix(msg, commandSearch))
+	rooms := server.searchableRooms(user.Name, user.Address)
+	found := false
+	for _, searchableRoom := range rooms {
+		found = found || searchableRoom == room
+	}
+	if !found {
+		rooms = append(rooms, room)
+	}
+	results, total, err := server.Search(text, rooms, 0, defaultSearchLimit)
+	if errors.Cause(err) == message.ErrEmptySearch {
+		user.ReceiveMessage("Words to search for are required.")
+		return
+	} else if err != nil {
+		logger.Printf("ERROR: failed to search for '%s' on behalf of %s: %+v\n", text, user.Name, err)
+		user.ReceiveMessage("Failed to search the rooms.")
+		return
+	} else if total == 0 {
+		user.ReceiveMessage(fmt.Sprintf("No messages match %s.", text))
+		return
+	}
+	lines := make([]string, 0, len(results))
+	for _, result := range results {
+		if !user.IsBlocked(result.Message.Sender) {
+			lines = append(lines, fmt.Sprintf("%s %s", result.Message.Room, result.Message.RoomMessage()))
+		}
+	}
+	user.ReceiveMessage(fmt.Sprintf("Messages matching %s (%d of %d):\n%s", text, len(results), total, strings.Join(lines, "\n")))
+}
+
+// SearchHandler handles searching the text of the messages of the rooms in the query, or every listed room the requester
+// can enter if no rooms are provided.
+func SearchHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	text := request.FormValue(parameterQuery)
+	logger.Printf("Received HTTP request to search for '%s'\n", text)
+	if !authorizeRead(writer, request) {
+		return
+	}
+	if len(strings.TrimSpace(text)) == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Println("ERROR: HTTP search request is missing the query")
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"Parameter 'q' is required"}`)
+		return
+	}
+	offset, ok := parseCountParameter(writer, request, parameterOffset, 0)
+	if !ok {
+		return
+	}
+	limit, ok := parseCountParameter(writer, request, parameterLimit, defaultSearchLimit)
+	if !ok {
+		return
+	}
+	if limit == 0 || limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	//
+	// Search the requested rooms, which must all be readable, or every room the requester can enter
+	//
+	var rooms []*ChatRoom
+	if roomNames := request.Form[parameterRoom]; len(roomNames) != 0 {
+		searched := make(map[string]bool)
+		for _, roomName := range roomNames {
+			room := server.FindRoom(roomName)
+			if room == nil {
+				writeRoomNotFound(writer, roomName)
+				return
+			} else if !authorizeRoom(writer, request, room) {
+				return
+			} else if !searched[roomName] {
+				searched[roomName] = true
+				rooms = append(rooms, room)
+			}
+		}
+	} else {
+		userName, ok := authenticateRequester(writer, request, scopeRead)
+		if !ok {
+			return
+		}
+		rooms = server.searchableRooms(userName, hostOf(request.RemoteAddr))
+	}
+	results, total, err := server.Search(text, rooms, offset, limit)
+	if errors.Cause(err) == message.ErrEmptySearch {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Printf("ERROR: HTTP search request query has no words: %s\n", text)
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"Parameter 'q' must contain a word"}`)
+		return
+	} else if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		logger.Printf("ERROR: failed to search for '%s': %+v\n", text, err)
+		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to search messages"}`)
+		return
+	}
+	response := searchResponse{Query: text, Total: total, Offset: offset, Results: results}
+	if offset+len(results) < total {
+		response.NextOffset = offset + len(results)
+	}
+	writeJSON(writer, http.StatusOK, response)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/piszmog/watercooler-chat/message"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatUser_handleInput_search(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var b bytes.Buffer
+	user := ChatUser{Name: "searchTester", writer: &b}
+	server.AddUser(&user)
+	room := server.CreateRoomIfMissing("searchRoom")
+	room.AddUser(user.Name)
+	privateRoom := server.CreateRoomIfMissing("privateSearchRoom")
+	privateRoom.SetModes(false, true)
+	room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "searchRoom", Sender: "tester", Value: "The release is on Friday"})
+	privateRoom.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "privateSearchRoom", Sender: "tester", Value: "The release is delayed"})
+	user.handleInput(`-search "the release"`, room)
+	if !strings.Contains(b.String(), "Messages matching \"the release\" (1 of 1):\nsearchRoom [") ||
+		strings.Contains(b.String(), "delayed") {
+		t.Fatalf("expected only the rooms the user can enter to be searched. Actual output %s", b.String())
+	}
+	user.handleInput("-search friday monday", room)
+	if !strings.Contains(b.String(), "No messages match friday monday.") {
+		t.Fatalf("expected every word to be required. Actual output %s", b.String())
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	room := server.CreateRoomIfMissing("searchHttpRoom")
+	otherRoom := server.CreateRoomIfMissing("otherSearchHttpRoom")
+	privateRoom := server.CreateRoomIfMissing("privateSearchHttpRoom")
+	privateRoom.SetModes(false, true)
+	for _, searchedRoom := range []*ChatRoom{room, otherRoom, privateRoom} {
+		searchedRoom.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: searchedRoom.Name, Sender: "tester", Value: "Deploy at five"})
+	}
+	req, err := http.NewRequest(http.MethodGet, "/search?q=deploy&limit=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SearchHandler).ServeHTTP(rr, req)
+	var response searchResponse
+	if err = json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || response.Total != 2 || len(response.Results) != 1 || response.NextOffset != 1 {
+		t.Fatalf("expected the first page of the rooms that are not restricted. Actual code %d and body %s", rr.Code, rr.Body.String())
+	}
+	req, err = http.NewRequest(http.MethodGet, "/search?q=deploy&room=searchHttpRoom", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(SearchHandler).ServeHTTP(rr, req)
+	response = searchResponse{}
+	if err = json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || response.Total != 1 || response.Results[0].Message.Room != "searchHttpRoom" {
+		t.Fatalf("expected only the requested room to be searched. Actual code %d and body %s", rr.Code, rr.Body.String())
+	}
+	for query, code := range map[string]int{
+		"/search?q=deploy&room=privateSearchHttpRoom": http.StatusUnauthorized,
+		"/search?q=deploy&room=missingRoom":           http.StatusNotFound,
+		"/search?q=deploy&offset=first":               http.StatusBadRequest,
+		"/search":                                     http.StatusBadRequest,
+	} {
+		req, err = http.NewRequest(http.MethodGet, query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(SearchHandler).ServeHTTP(rr, req)
+		if rr.Code != code {
+			t.Fatalf("expected %s to respond with %d. Actual code %d", query, code, rr.Code)
+		}
+	}
+}
@@ -1,32 +1,89 @@
 package main
 
 import (
+	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
 	"sync"
+	"time"
 )
 
 const defaultRoom = "main"
 
 // ChatServer is the server that is keeping rooms and users in-sync.
 type ChatServer struct {
-	rooms     map[string]*ChatRoom
-	roomsLock sync.RWMutex
-	users     map[string]*ChatUser
-	usersLock sync.RWMutex
+	rooms        map[string]*ChatRoom
+	roomsLock    sync.RWMutex
+	users        map[string]*ChatUser
+	usersLock    sync.RWMutex
+	store        *message.IndexedStore
+	direct       message.Store
+	profiles     map[string]*UserProfile
+	profilesLock sync.RWMutex
+	accounts     map[string]*Account
+	accountsLock sync.RWMutex
+	tokens       map[string]*APIToken
+	tokensLock   sync.RWMutex
+	statePath    string
+	savePending  bool
+	stateLock    sync.Mutex
+
+	// admins, tokensRequired and senderNameAllowed are the settings for authenticating requests
+	admins            map[string]bool
+	tokensRequired    bool
+	senderNameAllowed bool
+	authLock          sync.RWMutex
+}
+
+// UserProfile is the information about a user that is kept after the user leaves the server.
+type UserProfile struct {
+	Name         string                `json:"name"`
+	BlockedUsers []string              `json:"blockedUsers"`
+	FirstSeen    time.Time             `json:"firstSeen"`
+	LastSeen     time.Time             `json:"lastSeen"`
+	Mentions     []message.ChatMessage `json:"mentions,omitempty"`
 }
 
-// CreateServer creates the server.
+// CreateServer creates the server. The history of rooms and direct messages is kept in memory.
 func CreateServer() ChatServer {
+	return CreateServerWithStore(message.NewMemoryStore(), message.NewMemoryStore())
+}
+
+// CreateServerWithStore creates the server where the history of rooms is kept in the provided store and the history of
+// direct messages is kept in the provided direct store. The history of rooms is indexed so it can be searched.
+func CreateServerWithStore(store message.Store, directStore message.Store) ChatServer {
 	return ChatServer{
-		rooms:     make(map[string]*ChatRoom),
-		roomsLock: sync.RWMutex{},
-		users:     make(map[string]*ChatUser),
-		usersLock: sync.RWMutex{},
+		rooms:        make(map[string]*ChatRoom),
+		roomsLock:    sync.RWMutex{},
+		users:        make(map[string]*ChatUser),
+		usersLock:    sync.RWMutex{},
+		store:        message.NewIndexedStore(store),
+		direct:       directStore,
+		profiles:     make(map[string]*UserProfile),
+		profilesLock: sync.RWMutex{},
+		accounts:     make(map[string]*Account),
+		accountsLock: sync.RWMutex{},
+		admins:       make(map[string]bool),
+		authLock:     sync.RWMutex{},
+		tokens:       make(map[string]*APIToken),
+		tokensLock:   sync.RWMutex{},
+		stateLock:    sync.Mutex{},
 	}
 }
 
 // CreateRoomIfMissing creates the room with the specified name if it does not exist. If the room exists, the room matching
 // the name is returned.
 func (server *ChatServer) CreateRoomIfMissing(roomName string) *ChatRoom {
+	return server.createRoomIfMissing(roomName, "")
+}
+
+func (server *ChatServer) createRoomIfMissing(roomName string, owner string) *ChatRoom {
+	room, _ := server.createRoom(roomName, owner, nil)
+	return room
+}
+
+// createRoom creates the room with the owner if the room does not exist. The new room is configured before it is added to
+// the server, so the room is never seen without its settings. If the room already exists, it is returned with false.
+func (server *ChatServer) createRoom(roomName string, owner string, configure func(room *ChatRoom)) (*ChatRoom, bool) {
 	//
 	// To ensure concurrency safety, use lock
 	//
@@ -34,17 +91,27 @@ func (server *ChatServer) CreateRoomIfMissing(roomName string) *ChatRoom {
 	//
 	// Check if room exists - another goroutine could have created it
 	//
+	created := false
 	if server.rooms[roomName] == nil {
-		r := CreateRoom(roomName)
+		r := CreateRoomWithStore(roomName, server.store)
+		r.Owner = owner
+		if configure != nil {
+			configure(&r)
+		}
 		server.rooms[roomName] = &r
 		//
 		// Start the room's message handling
 		//
 		go r.HandleMessages()
 		logger.Printf("Room %s has been created\n", roomName)
+		created = true
 	}
+	room := server.rooms[roomName]
 	server.roomsLock.Unlock()
-	return server.rooms[roomName]
+	if created && server.keepsRoom(room) {
+		server.saveStateLater()
+	}
+	return room, created
 }
 
 // RemoveRoom removes the room from the server.
@@ -53,12 +120,16 @@ func (server *ChatServer) RemoveRoom(roomName string) {
 	// To ensure concurrency safety, lock writes to the chatRoom map
 	//
 	server.roomsLock.Lock()
-	if server.rooms[roomName] != nil {
-		server.rooms[roomName].Close()
+	room := server.rooms[roomName]
+	if room != nil {
+		room.Close()
 	}
 	delete(server.rooms, roomName)
 	server.roomsLock.Unlock()
-	logger.Printf("Room %s is empty. Room has been removed\n", roomName)
+	logger.Printf("Room %s has been removed\n", roomName)
+	if room != nil && server.keepsRoom(room) {
+		server.saveStateLater()
+	}
 }
 
 // GetRoom retrieves the room matching the specified room name.
@@ -70,28 +141,82 @@ func (server *ChatServer) GetRoom(roomName string) *ChatRoom {
 	return selectedRoom
 }
 
-// ListRooms returns a list of all room names in the server.
-func (server *ChatServer) ListRooms() []string {
+// OpenRoom retrieves the room matching the specified room name on behalf of the user. If the room does not exist, the room
+// is created with the user as its owner if the user's name is registered. If the user's name or address is banned from the
+// room, the user has not been invited to an invite only room, or the password of the room does not match, an error is
+// returned.
+func (server *ChatServer) OpenRoom(roomName string, userName string, address string, password string) (*ChatRoom, error) {
+	room := server.createRoomIfMissing(roomName, server.roomOwner(userName))
+	if room.IsBanned(userName, address) {
+		return nil, errors.Wrapf(errBanned, "%s cannot open room %s", userName, roomName)
+	}
+	if err := room.CheckAccess(userName, password); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// roomOwner is the owner of a room created by the user. Only registered names own the rooms they create, as any user can
+// take a name that is not registered once the name is free.
+func (server *ChatServer) roomOwner(userName string) string {
+	if server.HasAccount(userName) {
+		return userName
+	}
+	return ""
+}
+
+// keepsRoom checks if the room is kept when the last user leaves, and when the server is cycled. Only the default room and
+// rooms owned by registered names are kept, so the rooms saved in the state are the rooms that would still exist.
+func (server *ChatServer) keepsRoom(room *ChatRoom) bool {
+	return room.Name == defaultRoom || server.HasAccount(room.Owner)
+}
+
+// FindRoom retrieves the room matching the specified room name without creating it. If the room does not exist, nil is
+// returned.
+func (server *ChatServer) FindRoom(roomName string) *ChatRoom {
 	server.roomsLock.RLock()
-	rooms := server.rooms
+	room := server.rooms[roomName]
 	server.roomsLock.RUnlock()
-	roomList := make([]string, len(server.rooms))
-	index := 0
-	for _, exitingRoom := range rooms {
-		roomList[index] = exitingRoom.Name
-		index++
+	return room
+}
+
+// ListRooms returns a list of all room names in the server. Hidden rooms are not listed.
+func (server *ChatServer) ListRooms() []string {
+	server.roomsLock.RLock()
+	defer server.roomsLock.RUnlock()
+	roomList := make([]string, 0, len(server.rooms))
+	for _, exitingRoom := range server.rooms {
+		if !exitingRoom.IsHidden() {
+			roomList = append(roomList, exitingRoom.Name)
+		}
 	}
 	return roomList
 }
 
 // AddUser adds the user to the server.
 func (server *ChatServer) AddUser(user *ChatUser) {
+	//
+	// Start tracking the user's presence from when the user joined the server
+	//
+	if user.presence == nil {
+		user.presence = newPresence()
+	}
 	//
 	// Ensure concurrency safety
 	//
 	server.usersLock.Lock()
 	server.users[user.Name] = user
 	server.usersLock.Unlock()
+	//
+	// Keep track of when the user has been seen
+	//
+	server.updateProfile(user.Name, func(profile *UserProfile) {
+		now := time.Now()
+		if profile.FirstSeen.IsZero() {
+			profile.FirstSeen = now
+		}
+		profile.LastSeen = now
+	})
 }
 
 // GetUser retrieves the user matching the specified user name.
@@ -114,6 +239,9 @@ func (server *ChatServer) RemoveUser(userName string) {
 	delete(server.users, userName)
 	server.usersLock.Unlock()
 	logger.Printf("%s has left the server\n", userName)
+	server.updateProfile(userName, func(profile *UserProfile) {
+		profile.LastSeen = time.Now()
+	})
 }
 
 // UserExists checks if the user exists in the server.
@@ -129,3 +257,56 @@ func (server *ChatServer) UserExists(userName string) bool {
 	server.usersLock.RUnlock()
 	return exists
 }
+
+// GetProfile retrieves a copy of the profile of the user matching the specified user name. If the user has never been seen,
+// an empty profile is returned.
+func (server *ChatServer) GetProfile(userName string) UserProfile {
+	server.profilesLock.RLock()
+	defer server.profilesLock.RUnlock()
+	profile := server.profiles[userName]
+	if profile == nil {
+		return UserProfile{Name: userName}
+	}
+	profileCopy := *profile
+	profileCopy.BlockedUsers = append([]string(nil), profile.BlockedUsers...)
+	profileCopy.Mentions = append([]message.ChatMessage(nil), profile.Mentions...)
+	return profileCopy
+}
+
+// SetBlockedUsers updates the users blocked by the specified user so they can be restored when the user returns.
+func (server *ChatServer) SetBlockedUsers(userName string, blockedUsers []string) {
+	server.updateProfile(userName, func(profile *UserProfile) {
+		profile.BlockedUsers = blockedUsers
+	})
+}
+
+// updateProfile applies the update to the profile of the user. As profiles change whenever a user joins or leaves, the
+// state of the server is saved in the background.
+func (server *ChatServer) updateProfile(userName string, update func(profile *UserProfile)) {
+	server.changeProfile(userName, update)
+	server.saveStateLater()
+}
+
+// changeProfile applies the update to the profile of the user, creating the profile if the user does not have one, without
+// saving the state of the server.
+func (server *ChatServer) changeProfile(userName string, update func(profile *UserProfile)) {
+	server.profilesLock.Lock()
+	defer server.profilesLock.Unlock()
+	profile := server.profiles[userName]
+	if profile == nil {
+		profile = &UserProfile{Name: userName}
+		server.profiles[userName] = profile
+	}
+	update(profile)
+}
+
+// Close saves the state of the server and closes the stores holding the history of rooms and direct messages.
+func (server *ChatServer) Close() error {
+	if err := server.SaveState(); err != nil {
+		return err
+	}
+	if err := server.direct.Close(); err != nil {
+		return err
+	}
+	return server.store.Close()
+}
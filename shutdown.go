@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	shutdownTimeout = 10 * time.Second
+	messageShutdown = "The server is shutting down. Goodbye!"
+)
+
+var (
+	shutdownLock  sync.Mutex
+	shutdownHooks []func(ctx context.Context)
+)
+
+// onShutdown registers a function that stops a server from accepting connections when the chat server shuts down. The
+// function must return by the time the context is done.
+func onShutdown(hook func(ctx context.Context)) {
+	shutdownLock.Lock()
+	shutdownHooks = append(shutdownHooks, hook)
+	shutdownLock.Unlock()
+}
+
+// closeOnShutdown closes the listener when the chat server shuts down.
+func closeOnShutdown(listener net.Listener) {
+	onShutdown(func(ctx context.Context) {
+		_ = listener.Close()
+	})
+}
+
+// shutdown stops the servers from accepting connections, lets every room know the chat server is shutting down, and waits
+// for the rooms to handle the messages already sent to them. Servers, such as the HTTP server, have until the deadline to
+// finish handling their requests.
+func shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	stopped := stopAccepting(ctx)
+	server.Shutdown(ctx)
+	<-stopped
+	logger.Println("Stopped accepting connections")
+}
+
+// stopAccepting runs every shutdown hook at the same time. The returned channel is closed once every hook has returned.
+func stopAccepting(ctx context.Context) <-chan struct{} {
+	shutdownLock.Lock()
+	hooks := shutdownHooks
+	shutdownHooks = nil
+	shutdownLock.Unlock()
+	var wait sync.WaitGroup
+	for _, hook := range hooks {
+		wait.Add(1)
+		go func(hook func(ctx context.Context)) {
+			defer wait.Done()
+			hook(ctx)
+		}(hook)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		wait.Wait()
+		close(stopped)
+	}()
+	return stopped
+}
+
+// Shutdown lets the users in every room know the server is shutting down, then closes the rooms. Messages already sent to
+// a room are handled before the room stops, unless the context is done first.
+func (server *ChatServer) Shutdown(ctx context.Context) {
+	server.roomsLock.RLock()
+	rooms := make([]*ChatRoom, 0, len(server.rooms))
+	for _, room := range server.rooms {
+		rooms = append(rooms, room)
+	}
+	server.roomsLock.RUnlock()
+	for _, room := range rooms {
+		room.Broadcast(messageShutdown)
+		room.Close()
+	}
+	//
+	// Wait for each room to drain its messages
+	//
+	for _, room := range rooms {
+		select {
+		case <-room.stopped:
+		case <-ctx.Done():
+			logger.Printf("WARN: room %s did not finish handling messages before shutting down\n", room.Name)
+			return
+		}
+	}
+	logger.Printf("Handled the remaining messages of %d rooms\n", len(rooms))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/piszmog/watercooler-chat/message"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChatServer_Shutdown(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	//
+	// The room and the shutdown both write to the user
+	//
+	var b syncBuffer
+	user := &ChatUser{Name: "tester", writer: &b, blockedUsers: make(map[string]bool)}
+	server.AddUser(user)
+	room := server.CreateRoomIfMissing("testRoom")
+	room.AddUser("tester")
+	room.SendMessage(message.ChatMessage{Timestamp: time.Now(), Room: "testRoom", Sender: "tester1", Value: "Hello from a test"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+	//
+	// The message sent before shutting down is still handled
+	//
+	messages, err := room.GetMessages(message.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("expected the room to handle 1 message. Actual messages %v", messages)
+	}
+	if !strings.Contains(b.String(), messageShutdown) {
+		t.Fatalf("user was not notified of the shutdown. Actual output %s", b.String())
+	}
+	//
+	// Messages sent after the room is closed are dropped
+	//
+	room.SendMessage(message.ChatMessage{Timestamp: time.Now(), Room: "testRoom", Sender: "tester1", Value: "Too late"})
+}
+
+func TestStopAccepting(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnShutdown(listener)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	<-stopAccepting(ctx)
+	if _, err = listener.Accept(); err == nil {
+		t.Fatal("expected the listener to be closed")
+	}
+}
+
+// syncBuffer is a bytes.Buffer that can be written to from several goroutines.
+type syncBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.String()
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/reiver/go-telnet"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+)
+
+const (
+	defaultSSHPort         = "2222"
+	sshChannelSession      = "session"
+	sshRequestPty          = "pty-req"
+	sshRequestShell        = "shell"
+	sshRequestWindowChange = "window-change"
+	sshRequestExitStatus   = "exit-status"
+	charInterrupt          = 0x03
+	charEndOfTransmission  = 0x04
+)
+
+// StartSSHServer start a SSH server. The server is only started if a host key file and an authorized keys file are
+// provided in the configuration.
+func StartSSHServer(config configuration, done chan bool) {
+	if len(config.SSHHostKeyFile) == 0 || len(config.SSHAuthorizedKeysFile) == 0 {
+		logger.Println("A SSH host key file and authorized keys file were not provided. SSH server will not start.")
+		return
+	}
+	port := config.SSHPort
+	if len(port) == 0 {
+		logger.Printf("No SSH port provided in the configuration file. Using default SSH port '%s'\n", defaultSSHPort)
+		port = defaultSSHPort
+	}
+	ipAddress := config.IPAddress
+	if len(ipAddress) == 0 {
+		logger.Printf("No IP Address provided in the configuration file. Using default IP Address '%s'\n", defaultIPAddress)
+		ipAddress = defaultIPAddress
+	}
+	logger.Printf("Starting SSH server on '%s'...\n", ipAddress+":"+port)
+	//
+	// Create and start server
+	//
+	sshConfig, err := createSSHConfig(config.SSHHostKeyFile, config.SSHAuthorizedKeysFile)
+	if err != nil {
+		logger.Printf("failed to configure SSH server: %+v\n", err)
+		done <- true
+		return
+	}
+	listener, err := net.Listen("tcp", ipAddress+":"+port)
+	if err != nil {
+		logger.Printf("failed to start SSH server at address %s: %+v\n", ipAddress+":"+port, err)
+		done <- true
+		return
+	}
+	closeOnShutdown(listener)
+	if err = serveSSH(listener, sshConfig); err != nil {
+		logger.Printf("SSH server at address %s stopped: %+v\n", ipAddress+":"+port, err)
+	}
+	done <- true
+}
+
+// createSSHConfig creates the configuration of the SSH server. Clients must authenticate with one of the public keys in
+// the authorized keys file, connecting as the user name the key belongs to.
+func createSSHConfig(hostKeyFile string, authorizedKeysFile string) (*ssh.ServerConfig, error) {
+	hostKeyBytes, err := ioutil.ReadFile(hostKeyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read SSH host key file %s", hostKeyFile)
+	}
+	hostKey, err := ssh.ParsePrivateKey(hostKeyBytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse SSH host key file %s", hostKeyFile)
+	}
+	authorizedKeys, err := readAuthorizedKeys(authorizedKeysFile)
+	if err != nil {
+		return nil, err
+	}
+	sshConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			userName, ok := authorizedKeys[string(key.Marshal())]
+			if !ok {
+				logger.Printf("SSH client %s attempted to connect as %s with an unknown key\n", conn.RemoteAddr(), conn.User())
+				return nil, errors.Errorf("unknown public key for %s", conn.User())
+			}
+			if userName != conn.User() {
+				logger.Printf("SSH client %s attempted to connect as %s with the key of %s\n", conn.RemoteAddr(), conn.User(), userName)
+				return nil, errors.Errorf("public key does not belong to %s", conn.User())
+			}
+			return nil, nil
+		},
+	}
+	sshConfig.AddHostKey(hostKey)
+	return sshConfig, nil
+}
+
+// readAuthorizedKeys reads the public keys in the authorized keys file, mapped to the user name the key belongs to. The user
+// name is the comment of the key.
+func readAuthorizedKeys(authorizedKeysFile string) (map[string]string, error) {
+	authorizedKeysBytes, err := ioutil.ReadFile(authorizedKeysFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read SSH authorized keys file %s", authorizedKeysFile)
+	}
+	authorizedKeys := make(map[string]string)
+	for len(bytes.TrimSpace(authorizedKeysBytes)) != 0 {
+		publicKey, userName, _, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse SSH authorized keys file %s", authorizedKeysFile)
+		}
+		if len(userName) == 0 {
+			return nil, errors.Errorf("SSH authorized keys file %s has a key without a user name", authorizedKeysFile)
+		}
+		key := string(publicKey.Marshal())
+		if owner, ok := authorizedKeys[key]; ok && owner != userName {
+			return nil, errors.Errorf("SSH authorized keys file %s has the same key for %s and %s", authorizedKeysFile, owner, userName)
+		}
+		authorizedKeys[key] = userName
+		authorizedKeysBytes = rest
+	}
+	return authorizedKeys, nil
+}
+
+// serveSSH accepts SSH connections on the listener until the listener is closed.
+func serveSSH(listener net.Listener, sshConfig *ssh.ServerConfig) error {
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go handleSSHConnection(conn, sshConfig)
+	}
+}
+
+func handleSSHConnection(conn net.Conn, sshConfig *ssh.ServerConfig) {
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, sshConfig)
+	if err != nil {
+		logger.Printf("ERROR: SSH handshake with %s failed: %+v\n", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	defer serverConn.Close()
+	logger.Printf("SSH client %s connected as %s\n", serverConn.RemoteAddr(), serverConn.User())
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != sshChannelSession {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			logger.Printf("ERROR: failed to accept SSH channel from %s: %+v\n", serverConn.RemoteAddr(), err)
+			continue
+		}
+		go handleSSHSession(serverConn.User(), hostOf(serverConn.RemoteAddr().String()), channel, channelRequests)
+	}
+}
+
+// handleSSHSession waits for the client to request a shell, then hands the session to the same flow as a TELNET client
+// with the SSH user name as the user's name. The session ends if the name cannot be used.
+func handleSSHSession(userName string, address string, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	terminal := &sshTerminal{channel: channel}
+	shell := make(chan bool, 1)
+	go func() {
+		for request := range requests {
+			switch request.Type {
+			case sshRequestPty:
+				terminal.setPty()
+				_ = request.Reply(true, nil)
+			case sshRequestShell:
+				_ = request.Reply(true, nil)
+				shell <- true
+			case sshRequestWindowChange:
+				_ = request.Reply(true, nil)
+			default:
+				_ = request.Reply(false, nil)
+			}
+		}
+		close(shell)
+	}()
+	if !<-shell {
+		return
+	}
+	user := ChatUser{Name: userName, Address: address}
+	user.ServeTELNET(telnet.NewContext(), terminal, terminal)
+	//
+	// Let the client know the session ended normally
+	//
+	_, _ = channel.SendRequest(sshRequestExitStatus, false, ssh.Marshal(struct{ Status uint32 }{0}))
+}
+
+// sshTerminal adapts a SSH channel to the line based input and output a TELNET client provides. SSH clients do not echo
+// what is typed and expect carriage returns before new lines, unlike TELNET clients.
+type sshTerminal struct {
+	channel ssh.Channel
+	lock    sync.RWMutex
+	pty     bool
+}
+
+func (terminal *sshTerminal) setPty() {
+	terminal.lock.Lock()
+	terminal.pty = true
+	terminal.lock.Unlock()
+}
+
+// Read reads input from the client, echoing it back if the client has a terminal. Lines always end with a carriage return.
+func (terminal *sshTerminal) Read(data []byte) (int, error) {
+	n, err := terminal.channel.Read(data)
+	terminal.lock.RLock()
+	pty := terminal.pty
+	terminal.lock.RUnlock()
+	for index, b := range data[:n] {
+		if b == charInterrupt || b == charEndOfTransmission {
+			return index, io.EOF
+		}
+		//
+		// Without a terminal, lines end with only a new line
+		//
+		if !pty {
+			if b == charNewLine {
+				data[index] = charCarriageReturn
+			}
+			continue
+		}
+		if b == charCarriageReturn {
+			_, _ = terminal.channel.Write([]byte{charCarriageReturn, charNewLine})
+		} else {
+			_, _ = terminal.channel.Write([]byte{b})
+		}
+	}
+	return n, err
+}
+
+// Write writes output to the client, adding carriage returns before new lines.
+func (terminal *sshTerminal) Write(data []byte) (int, error) {
+	_, err := terminal.channel.Write(bytes.Replace(data, []byte{charNewLine}, []byte{charCarriageReturn, charNewLine}, -1))
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
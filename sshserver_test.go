@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestServeSSH_joinAndQuit(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	clientSigner, sshConfig, cleanup := setupSSHTest(t)
+	defer cleanup()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveSSH(listener, sshConfig)
+	defer listener.Close()
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "Tester",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientSigner)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	//
+	// Without a terminal, lines end with only a new line
+	//
+	session.Stdin = strings.NewReader("Test Room\n-q\n")
+	var output strings.Builder
+	session.Stdout = &output
+	if err = session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output.String(), "What is your Name?") {
+		t.Fatal("SSH user was asked for a name")
+	}
+	if !strings.Contains(output.String(), "Welcome to room Test Room! You may begin chatting with the users.\r\n") {
+		t.Fatalf("SSH user did not join the room. Actual output %s", output.String())
+	}
+}
+
+func TestServeSSH_unknownKey(t *testing.T) {
+	_, sshConfig, cleanup := setupSSHTest(t)
+	defer cleanup()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveSSH(listener, sshConfig)
+	defer listener.Close()
+	unknownKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknownSigner, err := ssh.NewSignerFromKey(unknownKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "Tester",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(unknownSigner)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		t.Fatal("expected a client with an unknown key to be rejected")
+	}
+}
+
+func TestServeSSH_otherUsersKey(t *testing.T) {
+	clientSigner, sshConfig, cleanup := setupSSHTest(t)
+	defer cleanup()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveSSH(listener, sshConfig)
+	defer listener.Close()
+	_, err = ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "Tester2",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientSigner)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		t.Fatal("expected a client connecting with the key of another user to be rejected")
+	}
+}
+
+func TestServeSSH_nameTaken(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	server.AddUser(&ChatUser{Name: "Tester"})
+	clientSigner, sshConfig, cleanup := setupSSHTest(t)
+	defer cleanup()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveSSH(listener, sshConfig)
+	defer listener.Close()
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "Tester",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientSigner)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	//
+	// The user cannot choose a name other than the name of the key
+	//
+	session.Stdin = strings.NewReader("Other Name\nTest Room\n-q\n")
+	var output strings.Builder
+	session.Stdout = &output
+	if err = session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output.String(), "What is your Name?") || server.UserExists("Other Name") {
+		t.Fatalf("SSH user was able to choose another name. Actual output %s", output.String())
+	}
+	if !strings.Contains(output.String(), "The Name Tester already exists on the server.") {
+		t.Fatalf("SSH user was not told the name is taken. Actual output %s", output.String())
+	}
+}
+
+// setupSSHTest writes a host key and an authorized keys file containing a client key, and creates the SSH configuration
+// from the files.
+func setupSSHTest(t *testing.T) (ssh.Signer, *ssh.ServerConfig, func()) {
+	directory, err := ioutil.TempDir("", "ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKeyBytes, err := x509.MarshalECPrivateKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKeyFile := path.Join(directory, "host_key")
+	err = ioutil.WriteFile(hostKeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: hostKeyBytes}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSigner, err := ssh.NewSignerFromKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//
+	// The comment of the key is the user name the key belongs to
+	//
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(clientSigner.PublicKey()))) + " Tester\n"
+	authorizedKeysFile := path.Join(directory, "authorized_keys")
+	if err = ioutil.WriteFile(authorizedKeysFile, []byte(authorizedKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sshConfig, err := createSSHConfig(hostKeyFile, authorizedKeysFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientSigner, sshConfig, func() {
+		os.RemoveAll(directory)
+	}
+}
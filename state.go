@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	stateFileName = "state.json"
+	// stateSaveDelay is how long changes that happen often, such as to rooms, profiles, and mentions, wait to be saved with
+	// other changes
+	stateSaveDelay = time.Second
+)
+
+// serverState is the snapshot of the server that is written to disk so rooms and users survive the server being cycled.
+type serverState struct {
+	Rooms    []roomState   `json:"rooms"`
+	Profiles []UserProfile `json:"profiles"`
+	Accounts []Account     `json:"accounts"`
+	Tokens   []APIToken    `json:"tokens"`
+}
+
+// roomState is the snapshot of a single room.
+type roomState struct {
+	Name         string    `json:"name"`
+	Created      time.Time `json:"created"`
+	Owner        string    `json:"owner,omitempty"`
+	Moderators   []string  `json:"moderators,omitempty"`
+	Bans         []Ban     `json:"bans,omitempty"`
+	Hidden       bool      `json:"hidden,omitempty"`
+	InviteOnly   bool      `json:"inviteOnly,omitempty"`
+	PasswordHash []byte    `json:"passwordHash,omitempty"`
+	Invited      []string  `json:"invited,omitempty"`
+	Topics       []Topic   `json:"topics,omitempty"`
+	Pins         []Pin     `json:"pins,omitempty"`
+}
+
+// LoadState restores the rooms, user profiles, accounts, and tokens from the snapshot at the specified path. Once loaded, the server writes
+// a new snapshot to the path whenever rooms, user profiles, accounts, or tokens change. If no snapshot exists yet, the server starts empty.
+// Only the rooms kept when the last user leaves are in the snapshot, being the default room and rooms owned by registered names.
+func (server *ChatServer) LoadState(statePath string) error {
+	stateBytes, err := ioutil.ReadFile(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to read state file %s", statePath)
+	}
+	if err == nil {
+		var state serverState
+		if err = json.Unmarshal(stateBytes, &state); err != nil {
+			return errors.Wrapf(err, "failed to parse state file %s", statePath)
+		}
+		server.restore(state)
+		logger.Printf("Restored %d rooms, %d user profiles, %d accounts, and %d tokens from '%s'\n", len(state.Rooms), len(state.Profiles), len(state.Accounts), len(state.Tokens), statePath)
+	}
+	server.stateLock.Lock()
+	server.statePath = statePath
+	server.stateLock.Unlock()
+	return nil
+}
+
+// SaveState writes a snapshot of the rooms, user profiles, accounts, and tokens to the state file. If state has not been loaded, nothing
+// is written.
+func (server *ChatServer) SaveState() error {
+	server.stateLock.Lock()
+	defer server.stateLock.Unlock()
+	if len(server.statePath) == 0 {
+		return nil
+	}
+	stateBytes, err := json.MarshalIndent(server.snapshot(), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize server state")
+	}
+	//
+	// Write to a temporary file first so a crash cannot leave a partially written snapshot. Only the server's user can
+	// read the file, as it contains password and token hashes
+	//
+	if err = ioutil.WriteFile(server.statePath+".tmp", stateBytes, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write state file %s", server.statePath)
+	}
+	if err = os.Rename(server.statePath+".tmp", server.statePath); err != nil {
+		return errors.Wrapf(err, "failed to replace state file %s", server.statePath)
+	}
+	return nil
+}
+
+func (server *ChatServer) saveState() {
+	if err := server.SaveState(); err != nil {
+		logger.Printf("ERROR: failed to save server state: %+v\n", err)
+	}
+}
+
+// saveStateLater saves the state of the server after a delay, so changes made in the meantime are written in the same
+// snapshot.
+func (server *ChatServer) saveStateLater() {
+	server.stateLock.Lock()
+	defer server.stateLock.Unlock()
+	if len(server.statePath) == 0 || server.savePending {
+		return
+	}
+	server.savePending = true
+	time.AfterFunc(stateSaveDelay, func() {
+		server.stateLock.Lock()
+		server.savePending = false
+		server.stateLock.Unlock()
+		server.saveState()
+	})
+}
+
+func (server *ChatServer) snapshot() serverState {
+	state := serverState{
+		Rooms:    make([]roomState, 0),
+		Profiles: make([]UserProfile, 0),
+		Accounts: make([]Account, 0),
+		Tokens:   make([]APIToken, 0),
+	}
+	server.roomsLock.RLock()
+	for _, room := range server.rooms {
+		//
+		// Rooms that are removed once empty would never be removed after being restored, as no one is in them
+		//
+		if !server.keepsRoom(room) {
+			continue
+		}
+		moderators := room.GetModerators()
+		sort.Strings(moderators)
+		savedRoom := roomState{
+			Name:       room.Name,
+			Created:    room.Created,
+			Owner:      room.Owner,
+			Moderators: moderators,
+			Bans:       room.GetBans(),
+			Invited:    room.GetInvited(),
+			Topics:     room.GetTopicHistory(),
+			Pins:       room.GetPins(),
+		}
+		room.moderationLock.RLock()
+		savedRoom.Hidden = room.hidden
+		savedRoom.InviteOnly = room.inviteOnly
+		savedRoom.PasswordHash = room.passwordHash
+		room.moderationLock.RUnlock()
+		state.Rooms = append(state.Rooms, savedRoom)
+	}
+	server.roomsLock.RUnlock()
+	server.profilesLock.RLock()
+	for _, profile := range server.profiles {
+		state.Profiles = append(state.Profiles, *profile)
+	}
+	server.profilesLock.RUnlock()
+	server.accountsLock.RLock()
+	for _, account := range server.accounts {
+		state.Accounts = append(state.Accounts, *account)
+	}
+	server.accountsLock.RUnlock()
+	server.tokensLock.RLock()
+	for _, token := range server.tokens {
+		state.Tokens = append(state.Tokens, *token)
+	}
+	server.tokensLock.RUnlock()
+	//
+	// Keep the snapshot stable between writes
+	//
+	sort.Slice(state.Rooms, func(i, j int) bool {
+		return state.Rooms[i].Name < state.Rooms[j].Name
+	})
+	sort.Slice(state.Profiles, func(i, j int) bool {
+		return state.Profiles[i].Name < state.Profiles[j].Name
+	})
+	sort.Slice(state.Accounts, func(i, j int) bool {
+		return state.Accounts[i].Name < state.Accounts[j].Name
+	})
+	sort.Slice(state.Tokens, func(i, j int) bool {
+		return state.Tokens[i].ID < state.Tokens[j].ID
+	})
+	return state
+}
+
+func (server *ChatServer) restore(state serverState) {
+	for _, savedRoom := range state.Rooms {
+		room := server.createRoomIfMissing(savedRoom.Name, savedRoom.Owner)
+		room.Created = savedRoom.Created
+		room.moderationLock.Lock()
+		for _, moderator := range savedRoom.Moderators {
+			room.moderators[moderator] = true
+		}
+		room.bans = savedRoom.Bans
+		room.hidden = savedRoom.Hidden
+		room.inviteOnly = savedRoom.InviteOnly
+		room.passwordHash = savedRoom.PasswordHash
+		for _, userName := range savedRoom.Invited {
+			room.invited[userName] = true
+		}
+		room.moderationLock.Unlock()
+		room.topicLock.Lock()
+		room.topics = savedRoom.Topics
+		room.topicLock.Unlock()
+		room.pinLock.Lock()
+		room.pins = savedRoom.Pins
+		room.pinLock.Unlock()
+	}
+	server.profilesLock.Lock()
+	for index := range state.Profiles {
+		profile := state.Profiles[index]
+		server.profiles[profile.Name] = &profile
+	}
+	server.profilesLock.Unlock()
+	server.accountsLock.Lock()
+	for index := range state.Accounts {
+		account := state.Accounts[index]
+		server.accounts[account.Name] = &account
+	}
+	server.accountsLock.Unlock()
+	server.tokensLock.Lock()
+	for index := range state.Tokens {
+		token := state.Tokens[index]
+		server.tokens[token.Hash] = &token
+	}
+	server.tokensLock.Unlock()
+}
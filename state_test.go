@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/piszmog/watercooler-chat/message"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestChatServer_SaveState(t *testing.T) {
+	directory, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	statePath := path.Join(directory, stateFileName)
+	server := CreateServer()
+	if err = server.LoadState(statePath); err != nil {
+		t.Fatal(err)
+	}
+	if err = server.RegisterAccount("tester", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	room, err := server.OpenRoom("testRoom", "tester", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.SetModerator("tester1", true)
+	room.SetModes(true, true)
+	room.Invite("tester4")
+	room.SetTopic("Persisted topic", "tester")
+	room.sendUserMessage(message.ChatMessage{Timestamp: time.Now(), Room: "testRoom", Sender: "tester", Value: "Read the rules"})
+	if err = room.Pin(1, "tester"); err != nil {
+		t.Fatal(err)
+	}
+	room.Ban(Ban{Name: "tester2", Address: "10.0.0.1", By: "tester"})
+	server.SetBlockedUsers("tester", []string{"tester1"})
+	//
+	// Rooms without a registered owner are removed once empty, so they are not kept either
+	//
+	server.CreateRoomIfMissing("guestRoom")
+	//
+	// Changes are saved in the background, so cycle the server before restoring the state in a new server
+	//
+	if err = server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	restoredServer := CreateServer()
+	if err = restoredServer.LoadState(statePath); err != nil {
+		t.Fatal(err)
+	}
+	restoredRoom := restoredServer.rooms["testRoom"]
+	if restoredRoom == nil {
+		t.Fatal("restored server does not contain the room 'testRoom'")
+	}
+	if restoredServer.rooms["guestRoom"] != nil {
+		t.Fatal("restored server contains the room 'guestRoom' that has no owner")
+	}
+	if restoredRoom.Owner != "tester" || !restoredRoom.IsModerator("tester1") {
+		t.Fatalf("restored room does not keep its owner and moderators. Actual owner %s", restoredRoom.Owner)
+	}
+	if !restoredRoom.IsBanned("tester3", "10.0.0.1") {
+		t.Fatal("restored room does not keep its bans")
+	}
+	if !restoredRoom.IsHidden() || !restoredRoom.IsInviteOnly() || !restoredRoom.IsInvited("tester4") {
+		t.Fatal("restored room does not keep its modes and invitations")
+	}
+	if topic, ok := restoredRoom.GetTopic(); !ok || topic.Value != "Persisted topic" {
+		t.Fatalf("restored room does not keep its topic. Actual topic %+v", topic)
+	}
+	if pins := restoredRoom.GetPins(); len(pins) != 1 || pins[0].ID != 1 || pins[0].PinnedBy != "tester" {
+		t.Fatalf("restored room does not keep its pinned messages. Actual pins %+v", pins)
+	}
+	blockedUsers := restoredServer.GetProfile("tester").BlockedUsers
+	if len(blockedUsers) != 1 || blockedUsers[0] != "tester1" {
+		t.Fatalf("restored profile does not block 'tester1'. Actual blocked users %v", blockedUsers)
+	}
+	if err = restoredServer.Authenticate("tester", "password1"); err != nil {
+		t.Fatalf("restored server does not authenticate 'tester': %+v", err)
+	}
+}
+
+func TestChatServer_LoadState_missingFile(t *testing.T) {
+	directory, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	server := CreateServer()
+	if err = server.LoadState(path.Join(directory, stateFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.ListRooms()) != 0 {
+		t.Fatal("expected no rooms when there is no state file")
+	}
+}
@@ -1,14 +1,25 @@
 package main
 
-import "github.com/reiver/go-telnet"
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"github.com/reiver/go-telnet"
+	"net"
+)
 
 const (
 	defaultTelnetPort = "5555"
+	// telnetIAC marks the start of a command, unless it is repeated
+	telnetIAC  = 255
+	telnetWill = 251
+	telnetSB   = 250
+	telnetSE   = 240
 )
 
 // StartTelnetServer start a TELNET server.
 func StartTelnetServer(config configuration, done chan bool) {
-	var userHandler = ChatUser{}
 	port := config.TelnetPort
 	if len(port) == 0 {
 		logger.Printf("No Telnet port provided in the configuration file. Using default Telnet port '%s'\n", defaultTelnetPort)
@@ -23,12 +34,10 @@ func StartTelnetServer(config configuration, done chan bool) {
 	//
 	// Start server
 	//
-	var err error
-	if len(config.CertificateFile) != 0 && len(config.KeyFile) != 0 {
-		err = telnet.ListenAndServeTLS(ipAddress+":"+port, config.CertificateFile, config.KeyFile, userHandler)
-	} else {
-		logger.Println("A certificate and key file were not provided. Telnet server will start in unsecured mode.")
-		err = telnet.ListenAndServe(ipAddress+":"+port, userHandler)
+	listener, err := listenTelnet(ipAddress+":"+port, config.CertificateFile, config.KeyFile)
+	if err == nil {
+		closeOnShutdown(listener)
+		err = serveTelnet(listener)
 	}
 	if nil != err {
 		//
@@ -38,3 +47,116 @@ func StartTelnetServer(config configuration, done chan bool) {
 	}
 	done <- true
 }
+
+// serveTelnet accepts TELNET connections on the listener until the listener is closed. Each connection is served by its
+// own user so the address the user connected from is known.
+func serveTelnet(listener net.Listener) error {
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go handleTelnetConnection(conn)
+	}
+}
+
+// handleTelnetConnection serves the TELNET client on the connection until the client quits, then closes the connection.
+func handleTelnetConnection(conn net.Conn) {
+	defer conn.Close()
+	user := ChatUser{Address: hostOf(conn.RemoteAddr().String())}
+	client := &telnetConn{conn: conn, reader: bufio.NewReader(conn)}
+	user.ServeTELNET(telnet.NewContext(), client, client)
+}
+
+// telnetConn adapts a connection to the data a TELNET client sends and receives. Commands the client sends, such as option
+// negotiation, are dropped from what is read, and data that would be read as a command by the client is escaped.
+type telnetConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Read reads the data sent by the client, returning as soon as some data has been read.
+func (client *telnetConn) Read(data []byte) (int, error) {
+	n := 0
+	for n < len(data) && (n == 0 || client.reader.Buffered() > 0) {
+		b, err := client.reader.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b != telnetIAC {
+			data[n] = b
+			n++
+			continue
+		}
+		command, err := client.reader.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		switch {
+		case command == telnetIAC:
+			//
+			// An escaped 255 is data
+			//
+			data[n] = telnetIAC
+			n++
+		case command >= telnetWill:
+			//
+			// Option negotiation is followed by the option
+			//
+			if _, err = client.reader.ReadByte(); err != nil {
+				return n, err
+			}
+		case command == telnetSB:
+			if err = client.skipSubnegotiation(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// skipSubnegotiation drops the data of a subnegotiation up to and including the command ending it.
+func (client *telnetConn) skipSubnegotiation() error {
+	for {
+		b, err := client.reader.ReadByte()
+		if err != nil {
+			return err
+		} else if b != telnetIAC {
+			continue
+		}
+		command, err := client.reader.ReadByte()
+		if err != nil {
+			return err
+		} else if command == telnetSE {
+			return nil
+		}
+	}
+}
+
+// Write writes the data to the client, escaping any 255 so the client does not read it as a command.
+func (client *telnetConn) Write(data []byte) (int, error) {
+	if _, err := client.conn.Write(bytes.Replace(data, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC}, -1)); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// listenTelnet listens for TELNET connections on the address. If a certificate and key file are provided, connections use
+// TLS (TELNETS).
+func listenTelnet(address string, certificateFile string, keyFile string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if len(certificateFile) == 0 || len(keyFile) == 0 {
+		logger.Println("A certificate and key file were not provided. Telnet server will start in unsecured mode.")
+		return listener, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(certificateFile, keyFile)
+	if err != nil {
+		_ = listener.Close()
+		return nil, errors.Wrapf(err, "failed to load certificate file %s and key file %s", certificateFile, keyFile)
+	}
+	return tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{certificate}}), nil
+}
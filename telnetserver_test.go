@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestHandleTelnetConnection_joinAndQuit(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	done := make(chan struct{})
+	go func() {
+		handleTelnetConnection(serverConn)
+		close(done)
+	}()
+	//
+	// Option negotiation from the client is not part of the name, and an escaped 255 is
+	//
+	go func() {
+		_, _ = clientConn.Write([]byte("\xff\xfd\x01\xff\xfa\x18\x01\xff\xf0Tester\xff\xff\n\rTest Room\n\r-q\n\r"))
+	}()
+	output, err := ioutil.ReadAll(clientConn)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(output, []byte("Welcome to room Test Room!")) {
+		t.Fatalf("TELNET user did not join the room. Actual output %s", output)
+	}
+	if !bytes.Contains(output, []byte("Tester\xff\xff has entered")) {
+		t.Fatalf("expected the 255 in the name to be escaped. Actual output %q", output)
+	}
+}
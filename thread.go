@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	commandReply           = "-reply"
+	commandThread          = "-thread"
+	pathRoomMessageReplies = "/rooms/{name}/messages/{id}/replies"
+	pathRoomMessageThread  = "/rooms/{name}/messages/{id}/thread"
+)
+
+// Reply sends the message to the room as a reply to the message with the ID. Deleted messages cannot be replied to.
+func (room *ChatRoom) Reply(parentID int64, senderName string, value string) error {
+	parent, err := room.GetMessage(parentID)
+	if err != nil {
+		return err
+	} else if parent.Deleted {
+		return errors.Wrapf(errMessageDeleted, "failed to reply to message %d of room %s", parentID, room.Name)
+	}
+	room.SendMessage(message.ChatMessage{
+		Timestamp:     time.Now(),
+		Room:          room.Name,
+		Sender:        senderName,
+		Value:         value,
+		ReplyTo:       parentID,
+		ReplyToSender: parent.Sender,
+		ReplyExcerpt:  parent.Excerpt(),
+	})
+	return nil
+}
+
+// GetThread retrieves the message with the ID followed by every reply to it, including replies to replies, in the order they
+// were sent.
+func (room *ChatRoom) GetThread(id int64) ([]message.ChatMessage, error) {
+	root, err := room.GetMessage(id)
+	if err != nil {
+		return nil, err
+	}
+	//
+	// Replies are always stored after the message they reply to, so a single pass over the messages after the root finds
+	// replies to replies
+	//
+	messages, err := room.GetMessages(message.Query{AfterID: id})
+	if err != nil {
+		return nil, err
+	}
+	thread := []message.ChatMessage{root}
+	inThread := map[int64]bool{id: true}
+	for _, chatMessage := range messages {
+		if chatMessage.ReplyTo != 0 && inThread[chatMessage.ReplyTo] && !inThread[chatMessage.ID] {
+			inThread[chatMessage.ID] = true
+			thread = append(thread, chatMessage)
+		}
+	}
+	return thread, nil
+}
+
+// reply sends the message as a reply to the message with the ID in the message.
+func (user *ChatUser) reply(msg string, room *ChatRoom) {
+	parts := strings.SplitN(msg, " ", 3)
+	if len(parts) < 3 || len(strings.TrimSpace(parts[2])) == 0 {
+		user.ReceiveMessage("A message ID and the reply are required.")
+		return
+	}
+	id, err := parseMessageID(parts[1])
+	if err != nil {
+		user.ReceiveMessage(fmt.Sprintf("%s is not a message ID.", parts[1]))
+		return
+	}
+	if !user.isInRoom(room) {
+		return
+	}
+	user.receiveMessageError(id, room.Reply(id, user.Name, parts[2]))
+}
+
+// showThread lets the user know of the message with the ID in the message and every reply to it.
+func (user *ChatUser) showThread(msg string, room *ChatRoom) {
+	parts := strings.Fields(msg)
+	if len(parts) < 2 {
+		user.ReceiveMessage("A message ID is required.")
+		return
+	}
+	id, err := parseMessageID(parts[1])
+	if err != nil {
+		user.ReceiveMessage(fmt.Sprintf("%s is not a message ID.", parts[1]))
+		return
+	}
+	thread, err := room.GetThread(id)
+	if err != nil {
+		user.receiveMessageError(id, err)
+		return
+	}
+	lines := make([]string, 0, len(thread))
+	for _, chatMessage := range thread {
+		if !user.IsBlocked(chatMessage.Sender) {
+			lines = append(lines, chatMessage.RoomMessage())
+		}
+	}
+	user.ReceiveMessage(fmt.Sprintf("Thread of message #%d:\n%s", id, strings.Join(lines, "\n")))
+}
+
+// RoomMessageRepliesHandler handles sending the body of the request to the room as a reply to the message in the path.
+func RoomMessageRepliesHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	variables := mux.Vars(request)
+	roomName := variables[pathVariableName]
+	logger.Printf("Received HTTP request to reply to message %s of room %s\n", variables[pathVariableID], roomName)
+	id, ok := parseMessageIDVariable(writer, variables)
+	if !ok {
+		return
+	}
+	senderName, ok := getSenderName(writer, request)
+	if !ok {
+		return
+	}
+	if server.FindRoom(roomName) == nil {
+		writeRoomNotFound(writer, roomName)
+		return
+	}
+	room, err := server.OpenRoom(roomName, senderName, hostOf(request.RemoteAddr), request.Header.Get(headerRoomPassword))
+	if err != nil {
+		writeRoomForbidden(writer, senderName, roomName, err)
+		return
+	}
+	msg, ok := readHTTPMessage(writer, request)
+	if !ok {
+		return
+	}
+	if err = room.Reply(id, senderName, msg); err != nil {
+		writeMessageError(writer, roomName, id, err)
+		return
+	}
+	writeSentResponse(writer, request)
+	logger.Printf("Sent HTTP reply to message %d of room %s from user %s\n", id, roomName, senderName)
+}
+
+// RoomMessageThreadHandler handles retrieving the message in the path along with every reply to it.
+func RoomMessageThreadHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	variables := mux.Vars(request)
+	roomName := variables[pathVariableName]
+	logger.Printf("Received HTTP request for the thread of message %s of room %s\n", variables[pathVariableID], roomName)
+	id, ok := parseMessageIDVariable(writer, variables)
+	if !ok {
+		return
+	}
+	if !authorizeRead(writer, request) {
+		return
+	}
+	room := server.FindRoom(roomName)
+	if room == nil {
+		writeRoomNotFound(writer, roomName)
+		return
+	}
+	if !authorizeRoom(writer, request, room) {
+		return
+	}
+	thread, err := room.GetThread(id)
+	if err != nil {
+		writeMessageError(writer, roomName, id, err)
+		return
+	}
+	writeMessages(writer, thread)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sendThread(room *ChatRoom) {
+	now := time.Now()
+	room.sendUserMessage(message.ChatMessage{Timestamp: now, Room: room.Name, Sender: "tester", Value: "Question"})
+	room.sendUserMessage(message.ChatMessage{Timestamp: now.Add(time.Millisecond), Room: room.Name, Sender: "tester1", Value: "Unrelated"})
+	room.sendUserMessage(message.ChatMessage{Timestamp: now.Add(2 * time.Millisecond), Room: room.Name, Sender: "tester1", Value: "Answer", ReplyTo: 1})
+	room.sendUserMessage(message.ChatMessage{Timestamp: now.Add(3 * time.Millisecond), Room: room.Name, Sender: "tester", Value: "Thanks", ReplyTo: 3})
+}
+
+func TestChatRoom_GetThread(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	room := server.CreateRoomIfMissing("threadRoom")
+	sendThread(room)
+	thread, err := room.GetThread(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thread) != 3 || thread[0].ID != 1 || thread[1].ID != 3 || thread[2].ID != 4 {
+		t.Fatalf("expected the message and its replies. Actual thread %+v", thread)
+	}
+}
+
+func TestChatUser_handleInput_reply(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var b bytes.Buffer
+	user := ChatUser{Name: "replyTester", writer: &b}
+	server.AddUser(&user)
+	room := server.CreateRoomIfMissing("replyRoom")
+	room.AddUser(user.Name)
+	sendThread(room)
+	messages, unsubscribe := room.Subscribe()
+	defer unsubscribe()
+	user.handleInput("-reply #2 Related after all", room)
+	select {
+	case <-messages:
+	case <-time.After(time.Second):
+		t.Fatal("expected the reply to be sent to the room")
+	}
+	user.handleInput("-reply 9 Hello?", room)
+	if !strings.Contains(b.String(), "Message #9 does not exist in the room.") {
+		t.Fatalf("expected a reply to a missing message to be rejected. Actual output %s", b.String())
+	}
+	thread, err := room.GetThread(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thread) != 2 || thread[1].Sender != "replyTester" || thread[1].ReplyTo != 2 || thread[1].ReplyToSender != "tester1" {
+		t.Fatalf("expected the reply to be in the thread. Actual thread %+v", thread)
+	}
+	user.handleInput("-thread 2", room)
+	if !strings.Contains(b.String(), `replyTester, reply to #2 by tester1: "Unrelated"]: Related after all`) {
+		t.Fatalf("expected the thread to be shown. Actual output %s", b.String())
+	}
+	//
+	// A banned user cannot reply, even though the room is still selected
+	//
+	room.Ban(Ban{Name: user.Name, By: "tester"})
+	user.handleInput("-reply 2 Sneaky", room)
+	if !strings.Contains(b.String(), "You are not in the room replyRoom.") {
+		t.Fatalf("expected the banned user to be told they are not in the room. Actual output %s", b.String())
+	}
+	if thread, _ = room.GetThread(2); len(thread) != 2 {
+		t.Fatalf("expected the reply of the banned user to be dropped. Actual thread %+v", thread)
+	}
+}
+
+func TestRoomMessageThreadHandler(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	server.AllowSenderName(true)
+	defer func() {
+		server = CreateServer()
+	}()
+	room := server.CreateRoomIfMissing("threadHttpRoom")
+	sendThread(room)
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}/messages/{id}/replies", RoomMessageRepliesHandler)
+	router.HandleFunc("/rooms/{name}/messages/{id}/thread", RoomMessageThreadHandler)
+	req, err := http.NewRequest(http.MethodGet, "/rooms/threadHttpRoom/messages/1/thread", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var thread []message.ChatMessage
+	if err = json.Unmarshal(rr.Body.Bytes(), &thread); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || len(thread) != 3 {
+		t.Fatalf("expected the thread of the message. Actual code %d and body %s", rr.Code, rr.Body.String())
+	}
+	req, err = http.NewRequest(http.MethodPost, "/rooms/threadHttpRoom/messages/9/replies", strings.NewReader("Hello?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(headerSenderName, "tester")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected a reply to a missing message to not be found. Actual code %d", rr.Code)
+	}
+}
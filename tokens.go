@@ -0,0 +1,335 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	pathTokens          = "/tokens"
+	pathToken           = "/tokens/{id}"
+	pathVariableID      = "id"
+	headerAuthorization = "Authorization"
+	bearerPrefix        = "Bearer "
+	bearerChallenge     = `Bearer realm="watercooler"`
+	scopeRead           = "read"
+	scopePost           = "post"
+	scopeAdmin          = "admin"
+	tokenIDLength       = 8
+	tokenSecretLength   = 32
+)
+
+var (
+	errInvalidScope  = errors.New("unknown token scope")
+	errTokenNotFound = errors.New("token does not exist")
+)
+
+// APIToken is a token that allows a user or bot to use the HTTP API. Only a hash of the token is kept, so the token itself
+// is only known when it is issued.
+type APIToken struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Scopes  []string  `json:"scopes"`
+	Hash    string    `json:"hash,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// issuedToken is the response to issuing a token, the only time the token is sent to the client.
+type issuedToken struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+// tokenRequest is the body of a HTTP request to issue a token.
+type tokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// hasScope checks if the token grants the scope. The admin scope grants every scope.
+func (token APIToken) hasScope(scope string) bool {
+	for _, tokenScope := range token.Scopes {
+		if tokenScope == scope || tokenScope == scopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAdmins sets the registered names that are allowed to administer the server.
+func (server *ChatServer) SetAdmins(userNames []string) {
+	server.authLock.Lock()
+	server.admins = make(map[string]bool)
+	for _, userName := range userNames {
+		server.admins[userName] = true
+	}
+	server.authLock.Unlock()
+}
+
+// IsAdmin checks if the name is allowed to administer the server. The name must be registered, otherwise anyone could
+// claim the name of an admin.
+func (server *ChatServer) IsAdmin(userName string) bool {
+	server.authLock.RLock()
+	admin := server.admins[userName]
+	server.authLock.RUnlock()
+	return admin && server.HasAccount(userName)
+}
+
+// RequireTokens sets whether the HTTP API only accepts requests with a bearer token.
+func (server *ChatServer) RequireTokens(required bool) {
+	server.authLock.Lock()
+	server.tokensRequired = required
+	server.authLock.Unlock()
+}
+
+// TokensRequired checks if the HTTP API only accepts requests with a bearer token.
+func (server *ChatServer) TokensRequired() bool {
+	server.authLock.RLock()
+	defer server.authLock.RUnlock()
+	return server.tokensRequired
+}
+
+// AllowSenderName sets whether HTTP requests without a token or credentials can name their sender with the 'Sender-Name'
+// header. The header is not authenticated, so it is only allowed when the server opts in.
+func (server *ChatServer) AllowSenderName(allowed bool) {
+	server.authLock.Lock()
+	server.senderNameAllowed = allowed
+	server.authLock.Unlock()
+}
+
+// SenderNameAllowed checks if HTTP requests can name their sender with the 'Sender-Name' header.
+func (server *ChatServer) SenderNameAllowed() bool {
+	server.authLock.RLock()
+	defer server.authLock.RUnlock()
+	return server.senderNameAllowed
+}
+
+// IssueToken creates a token for the name with the provided scopes. The token is returned along with the information kept
+// about the token.
+func (server *ChatServer) IssueToken(userName string, scopes []string) (string, APIToken, error) {
+	for _, scope := range scopes {
+		if scope != scopeRead && scope != scopePost && scope != scopeAdmin {
+			return "", APIToken{}, errors.Wrapf(errInvalidScope, "failed to issue token with scope %s", scope)
+		}
+	}
+	id, err := randomHex(tokenIDLength)
+	if err != nil {
+		return "", APIToken{}, errors.Wrapf(err, "failed to generate token ID for %s", userName)
+	}
+	secret, err := randomHex(tokenSecretLength)
+	if err != nil {
+		return "", APIToken{}, errors.Wrapf(err, "failed to generate token for %s", userName)
+	}
+	token := APIToken{
+		ID:      id,
+		Name:    userName,
+		Scopes:  scopes,
+		Hash:    hashToken(secret),
+		Created: time.Now(),
+	}
+	server.tokensLock.Lock()
+	server.tokens[token.Hash] = &token
+	server.tokensLock.Unlock()
+	logger.Printf("Issued token %s for %s with scopes %v\n", token.ID, userName, scopes)
+	server.saveState()
+	token.Hash = ""
+	return secret, token, nil
+}
+
+// FindToken retrieves the information of the provided token. If the token does not exist, false is returned.
+func (server *ChatServer) FindToken(secret string) (APIToken, bool) {
+	server.tokensLock.RLock()
+	defer server.tokensLock.RUnlock()
+	token := server.tokens[hashToken(secret)]
+	if token == nil {
+		return APIToken{}, false
+	}
+	return *token, true
+}
+
+// RevokeToken removes the token matching the ID so it can no longer be used.
+func (server *ChatServer) RevokeToken(id string) error {
+	server.tokensLock.Lock()
+	revoked := false
+	for hash, token := range server.tokens {
+		if token.ID == id {
+			delete(server.tokens, hash)
+			revoked = true
+		}
+	}
+	server.tokensLock.Unlock()
+	if !revoked {
+		return errors.Wrapf(errTokenNotFound, "failed to revoke token %s", id)
+	}
+	logger.Printf("Revoked token %s\n", id)
+	server.saveState()
+	return nil
+}
+
+// ListTokens returns the information of every token, without the token hashes.
+func (server *ChatServer) ListTokens() []APIToken {
+	server.tokensLock.RLock()
+	tokens := make([]APIToken, 0, len(server.tokens))
+	for _, token := range server.tokens {
+		tokenCopy := *token
+		tokenCopy.Hash = ""
+		tokens = append(tokens, tokenCopy)
+	}
+	server.tokensLock.RUnlock()
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+	return tokens
+}
+
+func hashToken(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomHex(length int) (string, error) {
+	randomBytes := make([]byte, length)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(randomBytes), nil
+}
+
+// TokensHandler handles listing and issuing tokens. Only admins can manage tokens.
+func TokensHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	if !authorizeAdmin(writer, request) {
+		return
+	}
+	if request.Method == http.MethodGet {
+		writeJSON(writer, http.StatusOK, server.ListTokens())
+		return
+	}
+	logger.Println("Received HTTP request to issue a token")
+	var body tokenRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil || len(body.Name) == 0 || len(body.Scopes) == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Println("ERROR: HTTP token request missing a name or scopes")
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"Body must contain a 'name' and 'scopes'"}`)
+		return
+	}
+	secret, token, err := server.IssueToken(body.Name, body.Scopes)
+	if errors.Cause(err) == errInvalidScope {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Printf("ERROR: HTTP token request has an unknown scope: %+v\n", err)
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"Scopes must be 'read', 'post', or 'admin'"}`)
+		return
+	} else if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		logger.Printf("ERROR: failed to issue token: %+v\n", err)
+		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to issue token"}`)
+		return
+	}
+	writeJSON(writer, http.StatusCreated, issuedToken{APIToken: token, Token: secret})
+}
+
+// TokenHandler handles revoking the token matching the ID in the path. Only admins can manage tokens.
+func TokenHandler(writer http.ResponseWriter, request *http.Request) {
+	//
+	// Always close the request body
+	//
+	defer closeBody(request.Body)
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	if !authorizeAdmin(writer, request) {
+		return
+	}
+	id := mux.Vars(request)[pathVariableID]
+	err := server.RevokeToken(id)
+	if errors.Cause(err) == errTokenNotFound {
+		writer.WriteHeader(http.StatusNotFound)
+		logger.Printf("ERROR: HTTP request to revoke token %s that does not exist\n", id)
+		writeHttpMessage(writer, `{"statusCode":"404", "reason":"Token does not exist"}`)
+		return
+	} else if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		logger.Printf("ERROR: failed to revoke token: %+v\n", err)
+		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to revoke token"}`)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+	writeHttpMessage(writer, `{"statusCode":"200", "reason":"Token successfully revoked."}`)
+}
+
+// authenticateToken checks the bearer token of the request grants the scope. If the request does not have a bearer token,
+// nil is returned. If the token does not exist or does not grant the scope, an error response is written and false is
+// returned.
+func authenticateToken(writer http.ResponseWriter, request *http.Request, scope string) (*APIToken, bool) {
+	authorization := request.Header.Get(headerAuthorization)
+	if !strings.HasPrefix(authorization, bearerPrefix) {
+		return nil, true
+	}
+	token, ok := server.FindToken(strings.TrimPrefix(authorization, bearerPrefix))
+	if !ok {
+		writeTokenRequired(writer, `{"statusCode":"401", "reason":"Invalid token"}`)
+		return nil, false
+	}
+	if !token.hasScope(scope) {
+		writer.WriteHeader(http.StatusForbidden)
+		logger.Printf("ERROR: token %s does not have the scope %s\n", token.ID, scope)
+		writeHttpMessage(writer, `{"statusCode":"403", "reason":"Token does not have the required scope"}`)
+		return nil, false
+	}
+	return &token, true
+}
+
+// authorizeRead checks the request is allowed to read messages. If the request is not authorized, an error response is
+// written and false is returned.
+func authorizeRead(writer http.ResponseWriter, request *http.Request) bool {
+	token, ok := authenticateToken(writer, request, scopeRead)
+	if ok && token == nil && server.TokensRequired() {
+		writeTokenRequired(writer, `{"statusCode":"401", "reason":"A token is required"}`)
+		return false
+	}
+	return ok
+}
+
+// authorizeAdmin checks the request is from an admin, either with a token with the admin scope or the credentials of an
+// admin's account. If the request is not authorized, an error response is written and false is returned.
+func authorizeAdmin(writer http.ResponseWriter, request *http.Request) bool {
+	token, ok := authenticateToken(writer, request, scopeAdmin)
+	if !ok || token != nil {
+		return ok
+	}
+	userName, password, ok := request.BasicAuth()
+	if ok && server.IsAdmin(userName) && server.Authenticate(userName, password) == nil {
+		return true
+	}
+	writeTokenRequired(writer, `{"statusCode":"401", "reason":"Admin credentials are required"}`)
+	return false
+}
+
+func writeTokenRequired(writer http.ResponseWriter, message string) {
+	writer.Header().Set(headerAuthenticate, bearerChallenge)
+	writer.WriteHeader(http.StatusUnauthorized)
+	logger.Println("ERROR: HTTP request does not have a valid token")
+	writeHttpMessage(writer, message)
+}
+
+func writeJSON(writer http.ResponseWriter, statusCode int, value interface{}) {
+	payload, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		logger.Printf("ERROR: failed to serialize HTTP response: %+v\n", err)
+		writeHttpMessage(writer, `{"statusCode":"500", "reason":"Failed to create response"}`)
+		return
+	}
+	writer.WriteHeader(statusCode)
+	writeHttpMessage(writer, string(payload))
+}
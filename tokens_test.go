@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChatServer_IssueToken(t *testing.T) {
+	server := CreateServer()
+	secret, token, err := server.IssueToken("bot", []string{scopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token.Hash) != 0 {
+		t.Fatal("issued token should not contain the hash")
+	}
+	found, ok := server.FindToken(secret)
+	if !ok || found.Name != "bot" || !found.hasScope(scopeRead) || found.hasScope(scopePost) {
+		t.Fatalf("unexpected token found %+v", found)
+	}
+	if err = server.RevokeToken(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = server.FindToken(secret); ok {
+		t.Fatal("expected revoked token to not be found")
+	}
+	if err = server.RevokeToken(token.ID); errors.Cause(err) != errTokenNotFound {
+		t.Fatalf("expected revoking token again to fail. Actual error %+v", err)
+	}
+	if _, _, err = server.IssueToken("bot", []string{"delete"}); errors.Cause(err) != errInvalidScope {
+		t.Fatalf("expected unknown scope to be rejected. Actual error %+v", err)
+	}
+}
+
+func TestChatServer_IsAdmin(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	server.SetAdmins([]string{"admin"})
+	room := server.CreateRoomIfMissing("adminRoom")
+	//
+	// Until the admin name is registered, anyone could claim it
+	//
+	if server.IsAdmin("admin") || room.IsOwner("admin") {
+		t.Fatal("expected an admin name that is not registered to be refused")
+	}
+	if err := server.RegisterAccount("admin", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	if !server.IsAdmin("admin") || !room.IsOwner("admin") {
+		t.Fatal("expected the registered admin to administer the server")
+	}
+}
+
+func TestTokensHandler_IssueAndPostMessage(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	if err := server.RegisterAccount("admin", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	server.SetAdmins([]string{"admin"})
+	router := mux.NewRouter()
+	router.HandleFunc("/tokens", TokensHandler)
+	router.HandleFunc("/rooms/{name}", RoomRequestHandler)
+	//
+	// Only admins can issue tokens
+	//
+	body := `{"name":"bot","scopes":["post"]}`
+	req, err := http.NewRequest(http.MethodPost, "/tokens", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+	req, err = http.NewRequest(http.MethodPost, "/tokens", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("admin", "password1")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	var issued issuedToken
+	if err = json.Unmarshal(rr.Body.Bytes(), &issued); err != nil {
+		t.Fatal(err)
+	}
+	//
+	// The sender is the token's name, not the header
+	//
+	req, err = http.NewRequest(http.MethodPost, "/rooms/main", bytes.NewBufferString("Message posted from test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+issued.Token)
+	req.Header.Add("Sender-Name", "tester")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	messages, err := server.GetRoom("main").WaitForMessages(message.Query{}, 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Sender != "bot" {
+		t.Fatalf("expected message from 'bot'. Actual messages %v", messages)
+	}
+	//
+	// The token cannot read messages
+	//
+	req, err = http.NewRequest(http.MethodGet, "/rooms/main", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+issued.Token)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+}
+
+func TestHandleRoomRequest_GetMessages_TokenRequired(t *testing.T) {
+	//
+	// Setup server
+	//
+	server = CreateServer()
+	server.RequireTokens(true)
+	defer func() {
+		server = CreateServer()
+	}()
+	req, err := http.NewRequest(http.MethodGet, "/rooms/main", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}", RoomRequestHandler)
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	commandTopic    = "-topic"
+	maxTopicHistory = 50
+)
+
+// Topic describes what a room is about, along with who set it and when.
+type Topic struct {
+	Value string    `json:"value"`
+	SetBy string    `json:"setBy"`
+	Set   time.Time `json:"set"`
+}
+
+// SetTopic changes the topic of the room. The previous topics are kept as the room's topic history.
+func (room *ChatRoom) SetTopic(value string, setBy string) Topic {
+	topic := Topic{
+		Value: value,
+		SetBy: setBy,
+		Set:   time.Now(),
+	}
+	room.topicLock.Lock()
+	room.topics = append(room.topics, topic)
+	if len(room.topics) > maxTopicHistory {
+		room.topics = room.topics[len(room.topics)-maxTopicHistory:]
+	}
+	room.topicLock.Unlock()
+	logger.Printf("%s changed the topic of the room %s\n", setBy, room.Name)
+	server.saveStateLater()
+	return topic
+}
+
+// GetTopic retrieves the current topic of the room. If no topic has been set, false is returned.
+func (room *ChatRoom) GetTopic() (Topic, bool) {
+	room.topicLock.RLock()
+	defer room.topicLock.RUnlock()
+	if len(room.topics) == 0 {
+		return Topic{}, false
+	}
+	return room.topics[len(room.topics)-1], true
+}
+
+// GetTopicHistory retrieves every topic the room has had, from oldest to newest.
+func (room *ChatRoom) GetTopicHistory() []Topic {
+	room.topicLock.RLock()
+	defer room.topicLock.RUnlock()
+	return append([]Topic(nil), room.topics...)
+}
+
+func (topic Topic) String() string {
+	return fmt.Sprintf("%s (set by %s on %s)", topic.Value, topic.SetBy, topic.Set.Format(time.RFC1123))
+}
+
+// changeTopic sets the topic of the room to the topic in the message. Without a topic, the topic and its history are shown.
+// Only users in the room can change the topic.
+func (user *ChatUser) changeTopic(msg string, room *ChatRoom) {
+	parts := strings.SplitN(msg, " ", 2)
+	if len(parts) < 2 || len(strings.TrimSpace(parts[1])) == 0 {
+		history := room.GetTopicHistory()
+		if len(history) == 0 {
+			user.ReceiveMessage("The room does not have a topic.")
+			return
+		}
+		lines := make([]string, len(history))
+		for index, topic := range history {
+			lines[index] = topic.String()
+		}
+		user.ReceiveMessage(fmt.Sprintf("Topics of the room, newest last:\n%s", strings.Join(lines, "\n")))
+		return
+	}
+	if !room.HasUser(user.Name) && !room.IsModerator(user.Name) {
+		user.ReceiveMessage(fmt.Sprintf("Only users in the room can use %s.", commandTopic))
+		return
+	}
+	topic := room.SetTopic(parts[1], user.Name)
+	room.Broadcast(fmt.Sprintf("%s changed the topic to: %s", user.Name, topic.Value))
+}
+
+// showTopic lets the user know the topic of the room, if the room has one.
+func (user *ChatUser) showTopic(room *ChatRoom) {
+	if topic, ok := room.GetTopic(); ok {
+		user.ReceiveMessage(fmt.Sprintf("Topic: %s", topic))
+	}
+}
+
+// formatRooms lists the names of the rooms along with their topics, one room per line.
+func formatRooms(roomNames []string) string {
+	lines := make([]string, len(roomNames))
+	for index, roomName := range roomNames {
+		lines[index] = roomName
+		if room := server.FindRoom(roomName); room != nil {
+			if topic, ok := room.GetTopic(); ok {
+				lines[index] = fmt.Sprintf("%s -- %s", roomName, topic.Value)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestChatRoom_SetTopic(t *testing.T) {
+	room := CreateRoom("testRoom")
+	if _, ok := room.GetTopic(); ok {
+		t.Fatal("expected a new room to not have a topic")
+	}
+	room.SetTopic("First topic", "tester")
+	room.SetTopic("Second topic", "tester1")
+	topic, ok := room.GetTopic()
+	if !ok || topic.Value != "Second topic" || topic.SetBy != "tester1" {
+		t.Fatalf("expected the latest topic to be the current topic. Actual topic %+v", topic)
+	}
+	if history := room.GetTopicHistory(); len(history) != 2 || history[0].Value != "First topic" {
+		t.Fatalf("expected the previous topic to be kept in the history. Actual history %+v", history)
+	}
+}
+
+func TestChatUser_handleInput_topic(t *testing.T) {
+	server = CreateServer()
+	defer func() {
+		server = CreateServer()
+	}()
+	var b bytes.Buffer
+	user := ChatUser{Name: "topicTester", writer: &b}
+	server.AddUser(&user)
+	room := server.CreateRoomIfMissing("topicRoom")
+	room.AddUser(user.Name)
+	user.handleInput("-topic Quarterly planning", room)
+	if topic, _ := room.GetTopic(); topic.Value != "Quarterly planning" {
+		t.Fatalf("expected the topic to be set. Actual topic %+v", topic)
+	}
+	user.handleInput("-lr", room)
+	if !strings.Contains(b.String(), "topicRoom -- Quarterly planning") {
+		t.Fatalf("expected the topic to be listed with the room. Actual output %s", b.String())
+	}
+}
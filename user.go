@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/piszmog/watercooler-chat/message"
+	"github.com/pkg/errors"
 	"github.com/reiver/go-oi"
 	"github.com/reiver/go-telnet"
 	"runtime/debug"
@@ -19,6 +20,8 @@ const (
 	commandListRooms        = "-lr"
 	commandListUsersInRoom  = "-lu"
 	commandListUsersBlocked = "-lb"
+	commandDirectMessage    = "-m"
+	commandRegister         = "-register"
 	commandQuit             = "-q"
 	commandHelp             = "-h"
 	commandHelpLong         = "-help"
@@ -29,18 +32,50 @@ const (
 		commandListRooms + "             -- to list all existing rooms\n" +
 		commandListUsersInRoom + "             -- to list all users in the current room\n" +
 		commandListUsersBlocked + "             -- to list all users currently blocked\n" +
+		commandDirectMessage + " ${user Name} ${message} -- to send a message directly to the specified user\n" +
+		commandRegister + " ${password} -- to register your Name so the password is required to use it\n" +
+		commandKick + " ${user Name} -- to remove the user from the room (moderators only)\n" +
+		commandBan + " ${user Name} [" + banOptionIP + "] -- to ban the user from the room, optionally by IP address as well (moderators only)\n" +
+		commandUnban + " ${user Name} -- to lift the bans of the user (moderators only)\n" +
+		commandListBans + "          -- to list all users banned from the room (moderators only)\n" +
+		commandModerator + " ${user Name} -- to make the user a moderator of the room (owner only)\n" +
+		commandUnmoderator + " ${user Name} -- to remove the user as a moderator of the room (owner only)\n" +
+		commandMode + " [" + roomModeHidden + "] [" + roomModeInvite + "] -- to hide the room from " + commandListRooms + " and/or only let invited users enter. No modes makes the room public (owner only)\n" +
+		commandRoomPassword + " [${password}] -- to require the password to enter the room. No password removes it (owner only)\n" +
+		commandInvite + " ${user Name} -- to invite the user to the room\n" +
+		commandTopic + " [${topic}] -- to set the topic of the room. No topic shows the topic and its history\n" +
+		commandWho + " ${user Name} -- to show if the user is online, the room they are in, and how long they have been idle\n" +
+		commandAway + " [${reason}] -- to let others know you are away. Direct messages are answered with the reason\n" +
+		commandBack + "           -- to let others know you are back\n" +
+		commandMentions + "       -- to list the messages you were mentioned in with @${user Name} since you last checked\n" +
+		commandEdit + " ${message ID} ${message} -- to change a message you sent. Moderators can change any message\n" +
+		commandDelete + " ${message ID} -- to delete a message you sent. Moderators can delete any message\n" +
+		commandReply + " ${message ID} ${message} -- to reply to the message\n" +
+		commandReact + " ${message ID} ${reaction} -- to react to the message with an emoji, such as :+1:\n" +
+		commandUnreact + " ${message ID} ${reaction} -- to remove your reaction to the message\n" +
+		commandThread + " ${message ID} -- to show the message and every reply to it\n" +
+		commandPin + " ${message ID} -- to pin the message to the room (moderators only)\n" +
+		commandUnpin + " ${message ID} -- to unpin the message from the room (moderators only)\n" +
+		commandPins + "           -- to list the pinned messages of the room\n" +
+		commandSearch + " ${words} -- to search the messages of the rooms you can enter. Use double quotes to search for a phrase\n" +
 		commandQuit + "              -- to quit the chat\n" +
 		commandHelp + "              -- to list all available commands\n"
+	maxRoomAttempts    = 3
 	charNewLine        = '\n'
 	charCarriageReturn = '\r'
 )
 
 // ChatUser is a user that can enter rooms and send messages to other users.
 type ChatUser struct {
-	Name   string
-	writer telnet.Writer
-	reader telnet.Reader
-	buffer message.Buffer
+	Name    string
+	Address string
+	writer  telnet.Writer
+	reader  telnet.Reader
+	buffer  message.Buffer
+	client  chatClient
+	// pendingRoom is the password protected room the user is entering the password of
+	pendingRoom string
+	presence    *presence
 	sync.RWMutex
 	blockedUsers map[string]bool
 }
@@ -70,16 +105,30 @@ func (user ChatUser) ServeTELNET(ctx telnet.Context, writer telnet.Writer, reade
 	//
 	// Determine the chatUser's name
 	//
-	user.selectName()
+	if !user.selectName() {
+		return
+	}
+	//
+	// Restore the users that were blocked in previous visits
+	//
+	for _, blockedUser := range server.GetProfile(user.Name).BlockedUsers {
+		user.block(blockedUser)
+	}
 	//
 	// Let user choose room they want to join
 	//
 	room := user.selectRoom()
+	if room == nil {
+		server.RemoveUser(user.Name)
+		return
+	}
 	//
 	// Let the user know who else is in the chatRoom
 	//
 	users := room.GetUsers()
 	user.ReceiveMessage(fmt.Sprintf("Users currently in the room:\n%s", strings.Join(users, "\n")))
+	user.showTopic(room)
+	user.showPins(room)
 	//
 	// Let user know of commands they can use
 	//
@@ -100,7 +149,18 @@ func (user ChatUser) ServeTELNET(ctx telnet.Context, writer telnet.Writer, reade
 	server.RemoveUser(user.Name)
 }
 
-func (user *ChatUser) selectName() {
+// selectName determines the name of the user. Returns false if the name provided when connecting cannot be used.
+func (user *ChatUser) selectName() bool {
+	//
+	// A name may have been provided when connecting, such as the SSH user name. The name is tied to how the user
+	// authenticated, so the user cannot choose a different name
+	//
+	if len(user.Name) != 0 && server.UserExists(user.Name) {
+		user.ReceiveMessage(fmt.Sprintf("The Name %s already exists on the server.", user.Name))
+		return false
+	} else if len(user.Name) != 0 && !user.login(user.Name) {
+		return false
+	}
 	//
 	// loop until the user has chosen an acceptable name
 	//
@@ -111,6 +171,8 @@ func (user *ChatUser) selectName() {
 			user.ReceiveMessage("A Name is required.")
 		} else if server.UserExists(userName) { // Do not allow a name already taken on the server
 			user.ReceiveMessage(fmt.Sprintf("The Name %s already exists on the server. Choose a different Name.", userName))
+		} else if !user.login(userName) { // Do not allow a registered name without the password
+			continue
 		} else { // An acceptable name has been chosen
 			user.Name = userName
 		}
@@ -119,6 +181,21 @@ func (user *ChatUser) selectName() {
 	// Update the server with the new user
 	//
 	server.AddUser(user) // todo multiple users with same name can get here if performed at the same exact time
+	return true
+}
+
+// login asks for the password if the name is registered. If the password is incorrect, false is returned.
+func (user *ChatUser) login(userName string) bool {
+	if !server.HasAccount(userName) {
+		return true
+	}
+	user.ReceiveMessage(fmt.Sprintf("The Name %s is registered. What is your password?", userName))
+	if err := server.Authenticate(userName, user.getInput()); err != nil {
+		logger.Printf("ERROR: failed login attempt as %s: %+v\n", userName, err)
+		user.ReceiveMessage("Incorrect password.")
+		return false
+	}
+	return true
 }
 
 func (user ChatUser) selectRoom() *ChatRoom {
@@ -126,35 +203,71 @@ func (user ChatUser) selectRoom() *ChatRoom {
 	// Get all current rooms on the sever
 	//
 	currentRooms := server.ListRooms()
-	user.ReceiveMessage(fmt.Sprintf("Existing rooms:\n%s", strings.Join(currentRooms, "\n")))
+	user.ReceiveMessage(fmt.Sprintf("Existing rooms:\n%s", formatRooms(currentRooms)))
 	//
 	// Get which room the user wants to go to/create
 	//
-	user.ReceiveMessage("What room would you like to enter (if room is not listed, room will be created)? ")
-	roomName := user.getInput()
-	//
-	// Get chatRoom, or create a new chatRoom
-	//
-	if len(roomName) == 0 {
-		roomName = defaultRoom
+	for attempt := 0; attempt < maxRoomAttempts; attempt++ {
+		user.ReceiveMessage("What room would you like to enter (if room is not listed, room will be created)? ")
+		roomName := user.getInput()
+		//
+		// Get chatRoom, or create a new chatRoom
+		//
+		if len(roomName) == 0 {
+			roomName = defaultRoom
+		}
+		//
+		// Retrieve the room, unless the user cannot enter it. Password protected rooms ask for the password
+		//
+		room, err := server.OpenRoom(roomName, user.Name, user.Address, "")
+		if errors.Cause(err) == errRoomPassword {
+			user.ReceiveMessage(fmt.Sprintf(messageRoomPassword, roomName))
+			room, err = server.OpenRoom(roomName, user.Name, user.Address, user.getInput())
+		}
+		if err == nil {
+			return room
+		}
+		user.ReceiveMessage(roomErrorMessage(roomName, err))
 	}
-	//
-	// Retrieve the room
-	//
-	return server.GetRoom(roomName)
+	user.ReceiveMessage("Could not enter a room.")
+	return nil
 }
 
 // ReceiveMessage writes the provided message to the client.
 func (user ChatUser) ReceiveMessage(message string) {
+	user.receive(clientEvent{Type: eventNotice, Value: message})
+}
+
+// ReceiveBroadcast writes the provided message that was broadcast to everyone in the room to the client.
+func (user *ChatUser) ReceiveBroadcast(roomName string, message string) {
+	user.receive(clientEvent{Type: eventBroadcast, Room: roomName, Value: message})
+}
+
+// ReceiveDirectMessage writes the provided message sent directly to the user to the client.
+func (user *ChatUser) ReceiveDirectMessage(chatMessage message.ChatMessage) {
+	user.receive(clientEvent{Type: eventDirect, Message: &chatMessage})
+}
+
+// ReceiveChatMessage writes the provided message sent by another user to the client.
+func (user *ChatUser) ReceiveChatMessage(chatMessage message.ChatMessage) {
+	user.receive(clientEvent{Type: eventMessage, Room: chatMessage.Room, Message: &chatMessage})
+}
+
+func (user *ChatUser) receive(event clientEvent) {
 	//
 	// Write the message to user
 	//
-	_, err := oi.LongWriteString(user.writer, message+"\n")
+	var err error
+	if user.client != nil {
+		err = user.client.send(event)
+	} else {
+		_, err = oi.LongWriteString(user.writer, event.text()+"\n")
+	}
 	if err != nil {
 		//
 		// Something terrible happened - log it
 		//
-		logger.Printf("ERROR: failed to send message %s to client %s: %+v\n", message, user.Name, err)
+		logger.Printf("ERROR: failed to send message %s to client %s: %+v\n", event.text(), user.Name, err)
 	}
 }
 
@@ -196,29 +309,10 @@ func (user ChatUser) handleMessages(room *ChatRoom) {
 				//
 				// Send message to all other users
 				//
-				msg := user.buffer.String()
-				//
-				// Check if message is a command
-				//
-				if strings.HasPrefix(msg, commandChangeRoom) { // change rooms
-					selectedRoom = user.changeRoom(selectedRoom, msg)
-				} else if strings.HasPrefix(msg, commandBlockUser) { // block a user
-					user.blockUser(msg)
-				} else if strings.HasPrefix(msg, commandUnblockUser) { // unblock as user
-					user.unblockUser(msg)
-				} else if msg == commandListRooms { // list existing rooms
-					user.ReceiveMessage(fmt.Sprintf("Existing rooms:\n%s", strings.Join(server.ListRooms(), "\n")))
-				} else if msg == commandListUsersInRoom { // list users in the current room
-					user.ReceiveMessage(fmt.Sprintf("Users currently in the room:\n%s\n", strings.Join(selectedRoom.GetUsers(), "\n")))
-				} else if msg == commandListUsersBlocked { // list blocked users
-					user.ReceiveMessage(strings.Join(user.getBlocked(), "\n"))
-				} else if msg == commandQuit { // quit the server
-					user.ReceiveMessage("Quiting...")
+				var stay bool
+				selectedRoom, stay = user.handleInput(user.buffer.String(), selectedRoom)
+				if !stay {
 					break
-				} else if msg == commandHelp || msg == commandHelpLong { // print commands
-					user.ReceiveMessage(messageCommands)
-				} else { // send message to other users in the room
-					user.SendMessage(msg, selectedRoom)
 				}
 			} else {
 				user.buffer.WriteByte(b)
@@ -233,6 +327,90 @@ func (user ChatUser) handleMessages(room *ChatRoom) {
 	}
 }
 
+// handleInput handles a line of input from the user. The input is either a command or a message to send to the room. The
+// room the user is in after handling the input is returned along with false if the user has quit.
+func (user *ChatUser) handleInput(msg string, selectedRoom *ChatRoom) (*ChatRoom, bool) {
+	user.markActive()
+	//
+	// The line after asking for the password of a room is the password
+	//
+	if len(user.pendingRoom) != 0 {
+		roomName := user.pendingRoom
+		user.pendingRoom = ""
+		return user.enterRoom(selectedRoom, roomName, msg), true
+	}
+	//
+	// Check if message is a command
+	//
+	command := strings.SplitN(msg, " ", 2)[0]
+	switch command {
+	case commandChangeRoom: // change rooms
+		selectedRoom = user.changeRoom(selectedRoom, msg)
+	case commandBlockUser: // block a user
+		user.blockUser(msg)
+	case commandUnblockUser: // unblock as user
+		user.unblockUser(msg)
+	case commandListRooms: // list existing rooms
+		user.ReceiveMessage(fmt.Sprintf("Existing rooms:\n%s", formatRooms(server.ListRooms())))
+	case commandListUsersInRoom: // list users in the current room
+		user.ReceiveMessage(fmt.Sprintf("Users currently in the room:\n%s\n", formatUsers(selectedRoom.GetUsers())))
+	case commandListUsersBlocked: // list blocked users
+		user.ReceiveMessage(strings.Join(user.getBlocked(), "\n"))
+	case commandDirectMessage: // send a message directly to a user
+		user.sendDirectMessage(msg)
+	case commandRegister: // register the user's name
+		user.register(msg)
+	case commandKick, commandBan, commandUnban, commandListBans, commandModerator, commandUnmoderator: // moderate the room
+		user.moderate(command, msg, selectedRoom)
+	case commandMode, commandRoomPassword: // change who can see and enter the room
+		user.configureRoom(command, msg, selectedRoom)
+	case commandInvite: // invite a user to the room
+		user.invite(msg, selectedRoom)
+	case commandTopic: // change or show the topic of the room
+		user.changeTopic(msg, selectedRoom)
+	case commandWho: // show the status of a user
+		user.who(msg)
+	case commandAway, commandBack: // let others know the user is away or back
+		user.away(command, msg)
+	case commandMentions: // list the unread mentions of the user
+		user.listMentions()
+	case commandEdit, commandDelete: // edit or delete a message
+		user.changeMessage(command, msg, selectedRoom)
+	case commandReact, commandUnreact: // add or remove a reaction to a message
+		user.react(command, msg, selectedRoom)
+	case commandReply: // reply to a message
+		user.reply(msg, selectedRoom)
+	case commandThread: // show a message and its replies
+		user.showThread(msg, selectedRoom)
+	case commandPin, commandUnpin: // pin or unpin a message
+		user.pin(command, msg, selectedRoom)
+	case commandPins: // list the pinned messages
+		user.listPins(selectedRoom)
+	case commandSearch: // search the messages of the rooms
+		user.search(msg, selectedRoom)
+	case commandQuit: // quit the server
+		user.ReceiveMessage("Quiting...")
+		return selectedRoom, false
+	case commandHelp, commandHelpLong: // print commands
+		user.ReceiveMessage(messageCommands)
+	default: // send message to other users in the room
+		if user.isInRoom(selectedRoom) {
+			user.SendMessage(msg, selectedRoom)
+		}
+	}
+	return selectedRoom, true
+}
+
+// isInRoom checks the user is in the room and is not banned from it, so the user can change the room's history. If not, the
+// user is let know.
+func (user *ChatUser) isInRoom(room *ChatRoom) bool {
+	if room.HasUser(user.Name) && !room.IsBanned(user.Name, user.Address) {
+		return true
+	}
+	user.ReceiveMessage(fmt.Sprintf("You are not in the room %s. Use %s to enter a room.", room.Name, commandChangeRoom))
+	return false
+}
+
 // SendMessage sends the message from the user to the room.
 func (user ChatUser) SendMessage(msg string, room *ChatRoom) {
 	go room.SendMessage(message.ChatMessage{
@@ -243,13 +421,69 @@ func (user ChatUser) SendMessage(msg string, room *ChatRoom) {
 	})
 }
 
-func (user ChatUser) changeRoom(previousRoom *ChatRoom, message string) *ChatRoom {
-	user.leave(previousRoom)
+func (user *ChatUser) sendDirectMessage(msg string) {
+	parts := strings.SplitN(msg, " ", 3)
+	if len(parts) < 3 || len(parts[1]) == 0 {
+		user.ReceiveMessage("A user Name and message are required.")
+		return
+	}
+	err := server.SendDirectMessage(message.ChatMessage{
+		Timestamp: time.Now(),
+		Sender:    user.Name,
+		Recipient: parts[1],
+		Value:     parts[2],
+	})
+	if errors.Cause(err) == errUserNotFound {
+		user.ReceiveMessage(fmt.Sprintf("%s is not on the server", parts[1]))
+	} else if err != nil {
+		logger.Printf("ERROR: failed to send direct message from %s: %+v\n", user.Name, err)
+		user.ReceiveMessage("Failed to send the message.")
+	}
+}
+
+func (user *ChatUser) register(msg string) {
+	parts := strings.SplitN(msg, " ", 2)
+	if len(parts) < 2 {
+		user.ReceiveMessage("A password is required.")
+		return
+	}
+	err := server.RegisterAccount(user.Name, parts[1])
+	switch errors.Cause(err) {
+	case nil:
+		user.ReceiveMessage(fmt.Sprintf("The Name %s has been registered. The password is required the next time the Name is used.", user.Name))
+	case errPasswordTooShort:
+		user.ReceiveMessage(fmt.Sprintf("The password must be at least %d characters.", minPasswordLength))
+	case errAccountExists:
+		user.ReceiveMessage(fmt.Sprintf("The Name %s is already registered.", user.Name))
+	default:
+		logger.Printf("ERROR: failed to register %s: %+v\n", user.Name, err)
+		user.ReceiveMessage("Failed to register the Name.")
+	}
+}
+
+func (user *ChatUser) changeRoom(previousRoom *ChatRoom, message string) *ChatRoom {
 	newRoomName := strings.Replace(message, commandChangeRoom+" ", "", 1)
+	return user.enterRoom(previousRoom, newRoomName, "")
+}
+
+// enterRoom leaves the previous room and enters the room with the password. If the room requires a password and none was
+// provided, the user is asked for the password and the next line the user enters is used as the password.
+func (user *ChatUser) enterRoom(previousRoom *ChatRoom, newRoomName string, password string) *ChatRoom {
+	newRoom, err := server.OpenRoom(newRoomName, user.Name, user.Address, password)
+	if errors.Cause(err) == errRoomPassword && len(password) == 0 {
+		user.pendingRoom = newRoomName
+		user.ReceiveMessage(fmt.Sprintf(messageRoomPassword, newRoomName))
+		return previousRoom
+	} else if err != nil {
+		user.ReceiveMessage(roomErrorMessage(newRoomName, err))
+		return previousRoom
+	}
+	user.leave(previousRoom)
 	user.ReceiveMessage("Changed rooms...")
-	newRoom := server.GetRoom(newRoomName)
 	users := newRoom.GetUsers()
 	user.ReceiveMessage(fmt.Sprintf("Users currently in the room:\n%s", strings.Join(users, "\n")))
+	user.showTopic(newRoom)
+	user.showPins(newRoom)
 	newRoom.AddUser(user.Name)
 	return newRoom
 }
@@ -272,9 +506,9 @@ func (user ChatUser) leave(room *ChatRoom) {
 	//
 	room.RemoveUser(user.Name)
 	//
-	// if no one is in the room, remove the room
+	// if no one is in the room, remove the room. Rooms owned by registered users are kept, along with their moderators and bans
 	//
-	if len(room.GetUsers()) == 0 && room.Name != defaultRoom {
+	if len(room.GetUsers()) == 0 && !server.keepsRoom(room) {
 		server.RemoveRoom(room.Name)
 	} else {
 		//
@@ -288,12 +522,14 @@ func (user *ChatUser) block(userName string) {
 	user.Lock()
 	user.blockedUsers[userName] = true
 	user.Unlock()
+	server.SetBlockedUsers(user.Name, user.getBlocked())
 }
 
 func (user *ChatUser) unblock(userName string) {
 	user.Lock()
 	user.blockedUsers[userName] = false
 	user.Unlock()
+	server.SetBlockedUsers(user.Name, user.getBlocked())
 }
 
 func (user *ChatUser) getBlocked() []string {
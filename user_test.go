@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"github.com/piszmog/watercooler-chat/message"
 	"github.com/reiver/go-telnet"
 	"strings"
 	"testing"
@@ -32,6 +33,32 @@ Available commands:
 -lr             -- to list all existing rooms
 -lu             -- to list all users in the current room
 -lb             -- to list all users currently blocked
+-m ${user Name} ${message} -- to send a message directly to the specified user
+-register ${password} -- to register your Name so the password is required to use it
+-kick ${user Name} -- to remove the user from the room (moderators only)
+-ban ${user Name} [ip] -- to ban the user from the room, optionally by IP address as well (moderators only)
+-unban ${user Name} -- to lift the bans of the user (moderators only)
+-lbans          -- to list all users banned from the room (moderators only)
+-mod ${user Name} -- to make the user a moderator of the room (owner only)
+-unmod ${user Name} -- to remove the user as a moderator of the room (owner only)
+-mode [hidden] [invite] -- to hide the room from -lr and/or only let invited users enter. No modes makes the room public (owner only)
+-roompass [${password}] -- to require the password to enter the room. No password removes it (owner only)
+-invite ${user Name} -- to invite the user to the room
+-topic [${topic}] -- to set the topic of the room. No topic shows the topic and its history
+-who ${user Name} -- to show if the user is online, the room they are in, and how long they have been idle
+-away [${reason}] -- to let others know you are away. Direct messages are answered with the reason
+-back           -- to let others know you are back
+-mentions       -- to list the messages you were mentioned in with @${user Name} since you last checked
+-edit ${message ID} ${message} -- to change a message you sent. Moderators can change any message
+-delete ${message ID} -- to delete a message you sent. Moderators can delete any message
+-reply ${message ID} ${message} -- to reply to the message
+-react ${message ID} ${reaction} -- to react to the message with an emoji, such as :+1:
+-unreact ${message ID} ${reaction} -- to remove your reaction to the message
+-thread ${message ID} -- to show the message and every reply to it
+-pin ${message ID} -- to pin the message to the room (moderators only)
+-unpin ${message ID} -- to unpin the message from the room (moderators only)
+-pins           -- to list the pinned messages of the room
+-search ${words} -- to search the messages of the rooms you can enter. Use double quotes to search for a phrase
 -q              -- to quit the chat
 -h              -- to list all available commands
 
@@ -69,6 +96,32 @@ Available commands:
 -lr             -- to list all existing rooms
 -lu             -- to list all users in the current room
 -lb             -- to list all users currently blocked
+-m ${user Name} ${message} -- to send a message directly to the specified user
+-register ${password} -- to register your Name so the password is required to use it
+-kick ${user Name} -- to remove the user from the room (moderators only)
+-ban ${user Name} [ip] -- to ban the user from the room, optionally by IP address as well (moderators only)
+-unban ${user Name} -- to lift the bans of the user (moderators only)
+-lbans          -- to list all users banned from the room (moderators only)
+-mod ${user Name} -- to make the user a moderator of the room (owner only)
+-unmod ${user Name} -- to remove the user as a moderator of the room (owner only)
+-mode [hidden] [invite] -- to hide the room from -lr and/or only let invited users enter. No modes makes the room public (owner only)
+-roompass [${password}] -- to require the password to enter the room. No password removes it (owner only)
+-invite ${user Name} -- to invite the user to the room
+-topic [${topic}] -- to set the topic of the room. No topic shows the topic and its history
+-who ${user Name} -- to show if the user is online, the room they are in, and how long they have been idle
+-away [${reason}] -- to let others know you are away. Direct messages are answered with the reason
+-back           -- to let others know you are back
+-mentions       -- to list the messages you were mentioned in with @${user Name} since you last checked
+-edit ${message ID} ${message} -- to change a message you sent. Moderators can change any message
+-delete ${message ID} -- to delete a message you sent. Moderators can delete any message
+-reply ${message ID} ${message} -- to reply to the message
+-react ${message ID} ${reaction} -- to react to the message with an emoji, such as :+1:
+-unreact ${message ID} ${reaction} -- to remove your reaction to the message
+-thread ${message ID} -- to show the message and every reply to it
+-pin ${message ID} -- to pin the message to the room (moderators only)
+-unpin ${message ID} -- to unpin the message from the room (moderators only)
+-pins           -- to list the pinned messages of the room
+-search ${words} -- to search the messages of the rooms you can enter. Use double quotes to search for a phrase
 -q              -- to quit the chat
 -h              -- to list all available commands
 
@@ -110,6 +163,32 @@ Available commands:
 -lr             -- to list all existing rooms
 -lu             -- to list all users in the current room
 -lb             -- to list all users currently blocked
+-m ${user Name} ${message} -- to send a message directly to the specified user
+-register ${password} -- to register your Name so the password is required to use it
+-kick ${user Name} -- to remove the user from the room (moderators only)
+-ban ${user Name} [ip] -- to ban the user from the room, optionally by IP address as well (moderators only)
+-unban ${user Name} -- to lift the bans of the user (moderators only)
+-lbans          -- to list all users banned from the room (moderators only)
+-mod ${user Name} -- to make the user a moderator of the room (owner only)
+-unmod ${user Name} -- to remove the user as a moderator of the room (owner only)
+-mode [hidden] [invite] -- to hide the room from -lr and/or only let invited users enter. No modes makes the room public (owner only)
+-roompass [${password}] -- to require the password to enter the room. No password removes it (owner only)
+-invite ${user Name} -- to invite the user to the room
+-topic [${topic}] -- to set the topic of the room. No topic shows the topic and its history
+-who ${user Name} -- to show if the user is online, the room they are in, and how long they have been idle
+-away [${reason}] -- to let others know you are away. Direct messages are answered with the reason
+-back           -- to let others know you are back
+-mentions       -- to list the messages you were mentioned in with @${user Name} since you last checked
+-edit ${message ID} ${message} -- to change a message you sent. Moderators can change any message
+-delete ${message ID} -- to delete a message you sent. Moderators can delete any message
+-reply ${message ID} ${message} -- to reply to the message
+-react ${message ID} ${reaction} -- to react to the message with an emoji, such as :+1:
+-unreact ${message ID} ${reaction} -- to remove your reaction to the message
+-thread ${message ID} -- to show the message and every reply to it
+-pin ${message ID} -- to pin the message to the room (moderators only)
+-unpin ${message ID} -- to unpin the message from the room (moderators only)
+-pins           -- to list the pinned messages of the room
+-search ${words} -- to search the messages of the rooms you can enter. Use double quotes to search for a phrase
 -q              -- to quit the chat
 -h              -- to list all available commands
 
@@ -150,6 +229,32 @@ Available commands:
 -lr             -- to list all existing rooms
 -lu             -- to list all users in the current room
 -lb             -- to list all users currently blocked
+-m ${user Name} ${message} -- to send a message directly to the specified user
+-register ${password} -- to register your Name so the password is required to use it
+-kick ${user Name} -- to remove the user from the room (moderators only)
+-ban ${user Name} [ip] -- to ban the user from the room, optionally by IP address as well (moderators only)
+-unban ${user Name} -- to lift the bans of the user (moderators only)
+-lbans          -- to list all users banned from the room (moderators only)
+-mod ${user Name} -- to make the user a moderator of the room (owner only)
+-unmod ${user Name} -- to remove the user as a moderator of the room (owner only)
+-mode [hidden] [invite] -- to hide the room from -lr and/or only let invited users enter. No modes makes the room public (owner only)
+-roompass [${password}] -- to require the password to enter the room. No password removes it (owner only)
+-invite ${user Name} -- to invite the user to the room
+-topic [${topic}] -- to set the topic of the room. No topic shows the topic and its history
+-who ${user Name} -- to show if the user is online, the room they are in, and how long they have been idle
+-away [${reason}] -- to let others know you are away. Direct messages are answered with the reason
+-back           -- to let others know you are back
+-mentions       -- to list the messages you were mentioned in with @${user Name} since you last checked
+-edit ${message ID} ${message} -- to change a message you sent. Moderators can change any message
+-delete ${message ID} -- to delete a message you sent. Moderators can delete any message
+-reply ${message ID} ${message} -- to reply to the message
+-react ${message ID} ${reaction} -- to react to the message with an emoji, such as :+1:
+-unreact ${message ID} ${reaction} -- to remove your reaction to the message
+-thread ${message ID} -- to show the message and every reply to it
+-pin ${message ID} -- to pin the message to the room (moderators only)
+-unpin ${message ID} -- to unpin the message from the room (moderators only)
+-pins           -- to list the pinned messages of the room
+-search ${words} -- to search the messages of the rooms you can enter. Use double quotes to search for a phrase
 -q              -- to quit the chat
 -h              -- to list all available commands
 
@@ -167,6 +272,32 @@ Available commands:
 -lr             -- to list all existing rooms
 -lu             -- to list all users in the current room
 -lb             -- to list all users currently blocked
+-m ${user Name} ${message} -- to send a message directly to the specified user
+-register ${password} -- to register your Name so the password is required to use it
+-kick ${user Name} -- to remove the user from the room (moderators only)
+-ban ${user Name} [ip] -- to ban the user from the room, optionally by IP address as well (moderators only)
+-unban ${user Name} -- to lift the bans of the user (moderators only)
+-lbans          -- to list all users banned from the room (moderators only)
+-mod ${user Name} -- to make the user a moderator of the room (owner only)
+-unmod ${user Name} -- to remove the user as a moderator of the room (owner only)
+-mode [hidden] [invite] -- to hide the room from -lr and/or only let invited users enter. No modes makes the room public (owner only)
+-roompass [${password}] -- to require the password to enter the room. No password removes it (owner only)
+-invite ${user Name} -- to invite the user to the room
+-topic [${topic}] -- to set the topic of the room. No topic shows the topic and its history
+-who ${user Name} -- to show if the user is online, the room they are in, and how long they have been idle
+-away [${reason}] -- to let others know you are away. Direct messages are answered with the reason
+-back           -- to let others know you are back
+-mentions       -- to list the messages you were mentioned in with @${user Name} since you last checked
+-edit ${message ID} ${message} -- to change a message you sent. Moderators can change any message
+-delete ${message ID} -- to delete a message you sent. Moderators can delete any message
+-reply ${message ID} ${message} -- to reply to the message
+-react ${message ID} ${reaction} -- to react to the message with an emoji, such as :+1:
+-unreact ${message ID} ${reaction} -- to remove your reaction to the message
+-thread ${message ID} -- to show the message and every reply to it
+-pin ${message ID} -- to pin the message to the room (moderators only)
+-unpin ${message ID} -- to unpin the message from the room (moderators only)
+-pins           -- to list the pinned messages of the room
+-search ${words} -- to search the messages of the rooms you can enter. Use double quotes to search for a phrase
 -q              -- to quit the chat
 -h              -- to list all available commands
 
@@ -177,6 +308,39 @@ Quiting...
 	}
 }
 
+func TestChatUser_login(t *testing.T) {
+	server = CreateServer()
+	//
+	// reset
+	//
+	defer func() {
+		server = CreateServer()
+	}()
+	if err := server.RegisterAccount("Tester", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	user := ChatUser{Name: "Tester1", writer: &b}
+	user.reader = strings.NewReader("password2\n\rpassword1\n\r")
+	user.buffer = message.Buffer{BufferBytes: make([]byte, 1, 1)}
+	if !user.login("Tester1") {
+		t.Fatal("expected a name without an account to be allowed")
+	}
+	if user.login("Tester") {
+		t.Fatal("expected an incorrect password to be rejected")
+	}
+	if !user.login("Tester") {
+		t.Fatal("expected the correct password to be accepted")
+	}
+	expectedServerMessages := `The Name Tester is registered. What is your password?
+Incorrect password.
+The Name Tester is registered. What is your password?
+`
+	if b.String() != expectedServerMessages {
+		t.Fatal("server did not write the expected format to the user")
+	}
+}
+
 func TestChatUser_ReceiveMessage(t *testing.T) {
 	var b bytes.Buffer
 	user := ChatUser{Name: "tester", writer: &b}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const pathRoomWebSocket = "/rooms/{name}/ws"
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// webSocketClient delivers events to a user connected over a WebSocket as JSON frames.
+type webSocketClient struct {
+	conn *websocket.Conn
+	lock sync.Mutex
+}
+
+func (client *webSocketClient) send(event clientEvent) error {
+	//
+	// WebSocket connections only support one concurrent writer
+	//
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	return client.conn.WriteJSON(event)
+}
+
+// WebSocketHandler upgrades the request to a WebSocket and joins the room as the user of the bearer token or the basic
+// authentication credentials. When the server allows it, the 'name' parameter or the 'Sender-Name' header can name an
+// unregistered user instead. Each text frame received is handled the same as a line entered by a TELNET user.
+func WebSocketHandler(writer http.ResponseWriter, request *http.Request) {
+	roomName := mux.Vars(request)[pathVariableName]
+	claimedName := request.FormValue(pathVariableName)
+	if len(claimedName) == 0 {
+		claimedName = request.Header.Get(headerSenderName)
+	}
+	//
+	// The user name must be known before joining the room
+	//
+	writer.Header().Add(headerContentType, headerContentTypeJSON)
+	userName, ok := authenticateSender(writer, request, scopePost, claimedName)
+	if !ok {
+		return
+	} else if len(userName) == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		logger.Println("ERROR: WebSocket request missing user name")
+		writeHttpMessage(writer, `{"statusCode":"400", "reason":"Missing parameter 'name'"}`)
+		return
+	}
+	if server.UserExists(userName) {
+		writer.WriteHeader(http.StatusConflict)
+		logger.Printf("ERROR: WebSocket request for name %s that already exists\n", userName)
+		writeHttpMessage(writer, `{"statusCode":"409", "reason":"The name already exists on the server"}`)
+		return
+	}
+	password := request.FormValue(parameterPassword)
+	if len(password) == 0 {
+		password = request.Header.Get(headerRoomPassword)
+	}
+	room, err := server.OpenRoom(roomName, userName, hostOf(request.RemoteAddr), password)
+	if err != nil {
+		writeRoomForbidden(writer, userName, roomName, err)
+		return
+	}
+	conn, err := upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		//
+		// The upgrader has already responded to the client
+		//
+		logger.Printf("ERROR: failed to upgrade to WebSocket: %+v\n", err)
+		return
+	}
+	defer closeWebSocket(conn)
+	//
+	// The HTTP server's timeouts do not apply to a long lived WebSocket
+	//
+	_ = conn.SetReadDeadline(time.Time{})
+	_ = conn.SetWriteDeadline(time.Time{})
+	logger.Printf("%s connected over WebSocket\n", userName)
+	user := ChatUser{
+		Name:         userName,
+		Address:      hostOf(request.RemoteAddr),
+		client:       &webSocketClient{conn: conn},
+		blockedUsers: make(map[string]bool),
+	}
+	server.AddUser(&user)
+	for _, blockedUser := range server.GetProfile(user.Name).BlockedUsers {
+		user.block(blockedUser)
+	}
+	//
+	// Join the room the same way a TELNET user does
+	//
+	user.ReceiveMessage(fmt.Sprintf("Users currently in the room:\n%s", strings.Join(room.GetUsers(), "\n")))
+	user.showTopic(room)
+	user.showPins(room)
+	user.ReceiveMessage(messageCommands)
+	room.AddUser(user.Name)
+	user.ReceiveMessage(fmt.Sprintf(messageWelcome, room.Name))
+	for {
+		messageType, frame, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		var stay bool
+		room, stay = user.handleInput(string(frame), room)
+		if !stay {
+			break
+		}
+	}
+	user.leave(room)
+	server.RemoveUser(user.Name)
+}
+
+func closeWebSocket(conn *websocket.Conn) {
+	err := conn.Close()
+	if err != nil {
+		logger.Printf("ERROR: failed to close WebSocket: %+v\n", err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebSocketHandler_receiveMessage(t *testing.T) {
+	server = CreateServer()
+	server.AllowSenderName(true)
+	defer func() {
+		server = CreateServer()
+	}()
+	//
+	// The server is hijacked by the WebSocket, so closing the test server does not wait for the handler
+	//
+	handled := make(chan struct{})
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}/ws", func(writer http.ResponseWriter, request *http.Request) {
+		WebSocketHandler(writer, request)
+		close(handled)
+	})
+	httpServer := httptest.NewServer(router)
+	defer httpServer.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/rooms/testRoom/ws?name=tester", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	//
+	// Wait until the user has joined the room
+	//
+	for {
+		var event clientEvent
+		if err = conn.ReadJSON(&event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Value == "Welcome to room testRoom! You may begin chatting with the users." {
+			break
+		}
+	}
+	room := server.GetRoom("testRoom")
+	sender := ChatUser{Name: "tester1"}
+	sender.SendMessage("Hello from a test", room)
+	var event clientEvent
+	if err = conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != eventMessage || event.Message == nil || event.Message.Value != "Hello from a test" {
+		t.Fatalf("expected the message from 'tester1'. Actual event %+v", event)
+	}
+	//
+	// Commands are handled the same as TELNET
+	//
+	if err = conn.WriteMessage(websocket.TextMessage, []byte("-q")); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != eventNotice || event.Value != "Quiting..." {
+		t.Fatalf("expected the quit notice. Actual event %+v", event)
+	}
+	//
+	// Wait for the handler and the room to stop before the server is reset
+	//
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WebSocket handler did not return")
+	}
+	room.Close()
+	<-room.stopped
+}
+
+func TestWebSocketHandler_missingName(t *testing.T) {
+	server = CreateServer()
+	server.AllowSenderName(true)
+	defer func() {
+		server = CreateServer()
+	}()
+	req, err := http.NewRequest(http.MethodGet, "/rooms/testRoom/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms/{name}/ws", WebSocketHandler)
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}